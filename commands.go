@@ -0,0 +1,973 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	gokeepasslib "github.com/tobischo/gokeepasslib/v3"
+	"github.com/vkuznet/kpass/vault"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Command represents kpass command
+type Command struct {
+	Name    string                                // command name
+	Aliases []string                              // command aliases
+	Args    string                                // arguments synopsis
+	Help    string                                // command description
+	MinArgs int                                   // minimal number of arguments
+	Batch   bool                                  // command can be used non-interactively
+	Extra   bool                                  // additional (non database) command
+	Handler func(s *session, args []string) error // command implementation
+}
+
+// Usage returns command usage string
+func (c *Command) Usage() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s", c.Name, c.Args))
+}
+
+// session represents state of opened databases used by commands
+type session struct {
+	kfile       string        // key file name used to encrypt/decrypt files
+	cipher      string        // cipher to encrypt/decrypt files
+	timeout     time.Duration // inactivity timeout
+	dbs         []*kdb        // opened databases
+	active      *kdb          // active database commands are routed to
+	interactive bool          // interactive session
+	script      bool          // script session, database writes are deferred
+	raw         []string      // raw arguments of running command as they were typed, see tokenizeRaw
+}
+
+// commandTable holds all kpass commands
+var commandTable []Command
+
+func init() {
+	commandTable = []Command{
+		{Name: "search", Args: "<query> [options]", Help: "search records sorted by relevance (any other input is a search query as well)",
+			MinArgs: 1, Batch: true, Handler: cmdSearch},
+		{Name: "saved", Args: "[rm <name>]", Help: "list saved searches (search --save <name>) or remove given one",
+			Batch: true, Handler: cmdSaved},
+		{Name: "run", Args: "<name> [options]", Help: "run saved search",
+			MinArgs: 1, Batch: true, Handler: cmdRun},
+		{Name: "url", Args: "<URL> [options]", Help: "find records matching URL of a login page",
+			MinArgs: 1, Batch: true, Handler: cmdURL},
+		{Name: "ls", Args: "[group]", Help: "list records, optionally within given group",
+			Batch: true, Handler: cmdList},
+		{Name: "recent", Args: "[N]", Help: "list N most recently used records, default 10",
+			Batch: true, Handler: cmdRecent},
+		{Name: "fav", Args: "[ID]", Help: "mark record ID as favorite or list favorite records",
+			Batch: true, Handler: cmdFavorite},
+		{Name: "unfav", Args: "<ID>", Help: "unmark favorite record ID",
+			MinArgs: 1, Batch: true, Handler: cmdUnfavorite},
+		{Name: "show", Args: "<ID> [--reveal]", Help: "show all fields of record ID (and protected ones)",
+			MinArgs: 1, Batch: true, Handler: cmdShow},
+		{Name: "get", Args: "<ID> [field]", Help: "print record ID field, default password",
+			MinArgs: 1, Batch: true, Handler: cmdGet},
+		{Name: "cp", Args: "<ID> [attribute]", Help: "copy record ID attribute to clipboard, default password",
+			MinArgs: 1, Handler: cmdCopy},
+		{Name: "add", Args: "<key|key=value> ...", Help: "add record key or new record with given values (password=- prompts for it)",
+			MinArgs: 1, Batch: true, Handler: cmdAdd},
+		{Name: "edit", Args: "<ID> <key=value> ...", Help: "edit record ID attributes",
+			MinArgs: 2, Batch: true, Handler: cmdEdit},
+		{Name: "rm", Args: "<ID>", Help: "remove record ID from database",
+			MinArgs: 1, Batch: true, Handler: cmdRemove},
+		{Name: "export", Args: "[options]", Help: "export records with plain text secrets, e.g. to CSV file",
+			Batch: true, Handler: cmdExport},
+		{Name: "import", Args: "csv <file> [options]", Help: "import records of CSV file, e.g. export of other password manager",
+			MinArgs: 2, Batch: true, Handler: cmdImport},
+		{Name: "save", Help: "save record in DB and write new DB file",
+			Handler: cmdSave},
+		{Name: "timeout", Args: "[int]", Help: "show or set timeout interval in seconds",
+			Handler: cmdTimeout},
+		{Name: "open", Args: "<path> [alias] [--kfile <file>]", Help: "open another database and make it active",
+			MinArgs: 1, Handler: cmdOpen},
+		{Name: "use", Args: "<alias>", Help: "make given database active",
+			MinArgs: 1, Handler: cmdUse},
+		{Name: "close", Args: "<alias> [--force]", Help: "close given database",
+			MinArgs: 1, Handler: cmdClose},
+		{Name: "dbs", Help: "list opened databases",
+			Handler: cmdDatabases},
+		{Name: "encrypt", Args: "<fname> [--password-file <file>]", Help: "encrypt given file",
+			MinArgs: 1, Extra: true, Handler: cmdEncrypt},
+		{Name: "decrypt", Args: "<fname> [--password-file <file>]", Help: "decrypt given file",
+			MinArgs: 1, Extra: true, Handler: cmdDecrypt},
+		{Name: "help", Help: "show this message",
+			Extra: true, Handler: cmdHelp},
+		{Name: "exit", Aliases: []string{"quit"}, Help: "exit kpass",
+			Extra: true, Handler: cmdExit},
+	}
+}
+
+// helper function to find command by its name or alias
+func findCommand(name string) *Command {
+	for i := range commandTable {
+		cmd := &commandTable[i]
+		if cmd.Name == name {
+			return cmd
+		}
+		for _, alias := range cmd.Aliases {
+			if alias == name {
+				return cmd
+			}
+		}
+	}
+	return nil
+}
+
+// helper function to print usage of given commands
+func printCommands(batch, extra bool) {
+	width := 0
+	for _, cmd := range commandTable {
+		if len(cmd.Usage()) > width {
+			width = len(cmd.Usage())
+		}
+	}
+	for _, cmd := range commandTable {
+		if (batch && !cmd.Batch) || (!batch && cmd.Extra != extra) {
+			continue
+		}
+		fmt.Printf("%-*s # %s\n", width, cmd.Usage(), cmd.Help)
+	}
+}
+
+// helper function to print non-interactive commands usage
+func commandsUsage() {
+	fmt.Println()
+	fmt.Println("Non-interactive commands, use: kpass [options] <command> [arguments]")
+	printCommands(true, false)
+	fmt.Println("completion bash|zsh|fish  # print shell completion script")
+	fmt.Println("tui                       # full-screen terminal UI")
+	fmt.Println()
+	fmt.Println("Record ID can be either record number or its group/title path.")
+	fmt.Println("Listing and lookup commands (search, run, url, recent, fav, get, show, ls) support")
+	fmt.Println("--format json|jsonl|yaml|table  # machine readable output")
+	fmt.Println("--reveal                        # include protected fields")
+	fmt.Println()
+	fmt.Println("Search options (see README for query syntax), url command supports paging ones:")
+	fmt.Println("--mode literal|fuzzy|regex|glob # matching of plain words, default literal")
+	fmt.Println("--case-sensitive                # case-sensitive matching")
+	fmt.Println("--all-fields                    # search custom fields, attachment names and history")
+	fmt.Println("--include-protected             # search protected fields")
+	fmt.Println("--limit N --page N              # show N records of given page")
+	fmt.Println()
+	fmt.Println("URL options:")
+	fmt.Println("--match host|subdomain|domain   # match host, its parent domains or registrable domain (default)")
+	fmt.Println("--strict                        # require the same scheme and port")
+	fmt.Println()
+	fmt.Println("Export options:")
+	fmt.Println("--format csv                    # KeePassXC compatible CSV, default")
+	fmt.Println("--columns Group,Title,...       # exported columns, default all")
+	fmt.Println("--group <group> --query <query> # export records of given group or search query")
+	fmt.Println("--output <file>                 # write file with 0600 permissions, default stdout")
+	fmt.Println("--yes                           # confirm export of plain text secrets")
+	fmt.Println()
+	fmt.Println("Import options:")
+	fmt.Println("--preset keepassxc|lastpass|bitwarden|firefox|chrome # columns of known exporter, detected by default")
+	fmt.Println("--map column=field              # import column as given field, - skips it")
+	fmt.Println("--group <group>                 # target group of imported records")
+	fmt.Println("--dry-run                       # show records to import and duplicates to skip")
+	fmt.Println()
+	fmt.Println("Exit codes:")
+	printExitCodes()
+}
+
+// helper function to add opened database to the session and make it active
+func (s *session) add(d *kdb) {
+	// make sure alias of database is unique
+	name := d.name
+	for i := 2; s.find(d.name) != nil; i++ {
+		d.name = fmt.Sprintf("%s%d", name, i)
+	}
+	d.deferred = s.script
+	s.dbs = append(s.dbs, d)
+	s.active = d
+	s.updatePrefixes()
+}
+
+// helper function to find opened database by its alias
+func (s *session) find(name string) *kdb {
+	for _, d := range s.dbs {
+		if d.name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// helper function to update record ID prefixes of opened databases, records
+// are prefixed by database alias only if several databases are opened
+func (s *session) updatePrefixes() {
+	for _, d := range s.dbs {
+		d.prefix = ""
+		if len(s.dbs) > 1 {
+			d.prefix = d.name + ":"
+		}
+	}
+}
+
+// helper function to resolve record key into database and its record key,
+// the key can be prefixed by database alias, e.g. team:5, otherwise active
+// database is used
+func (s *session) resolve(key string) (*kdb, string) {
+	if idx := strings.Index(key, ":"); idx > 0 {
+		if d := s.find(key[:idx]); d != nil {
+			return d, key[idx+1:]
+		}
+	}
+	return s.active, key
+}
+
+// helper function to return prompt of the session
+func (s *session) prompt() string {
+	if len(s.dbs) > 1 {
+		return fmt.Sprintf("%s # ", s.active.name)
+	}
+	return "db # "
+}
+
+// run executes given command with its arguments
+func (s *session) run(cmd *Command, args []string) error {
+	if len(args) < cmd.MinArgs {
+		return fmt.Errorf("%w, usage: %s", errUsage, cmd.Usage())
+	}
+	return cmd.Handler(s, args)
+}
+
+// execute parses given input line and executes its command, any input which
+// does not start with known command is used as search query
+func (s *session) execute(line string) error {
+	tokens, raw, err := tokenizeRaw(line)
+	if err != nil {
+		// input which is not a command is a search query and it may contain
+		// unbalanced quotes, e.g. O'Brien
+		if words := strings.Fields(line); len(words) > 0 && findCommand(words[0]) == nil {
+			return s.run(findCommand("search"), []string{strings.TrimSpace(line)})
+		}
+		return err
+	}
+	return s.dispatch(tokens, raw)
+}
+
+// helper function to execute command of given tokens, raw tokens are used as
+// search query if tokens do not start with known command
+func (s *session) dispatch(tokens, raw []string) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	cmd := findCommand(tokens[0])
+	if cmd == nil {
+		return s.run(findCommand("search"), []string{strings.Join(raw, " ")})
+	}
+	s.raw = raw[1:]
+	defer func() { s.raw = nil }()
+	return s.run(cmd, tokens[1:])
+}
+
+// helper function to run non-interactive command, it opens database,
+// performs given command and returns exit code
+func runCommand(kpath, kfile string, args []string) int {
+	cmd := findCommand(args[0])
+	if cmd == nil || !cmd.Batch {
+		log.Printf("ERROR: unknown command '%s'", args[0])
+		commandsUsage()
+		return exitUsage
+	}
+	if len(args)-1 < cmd.MinArgs {
+		log.Printf("ERROR: not enough arguments, usage: %s", cmd.Usage())
+		return exitUsage
+	}
+
+	d, err := openDB(kpath, kfile, pwdSource.read)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return exitCode(err)
+	}
+	s := &session{kfile: kfile}
+	s.add(d)
+	err = s.run(cmd, args[1:])
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+	return exitCode(err)
+}
+
+// searchHit represents search match within given database
+type searchHit struct {
+	db    *kdb
+	match vault.Match
+}
+
+// searchMode defines default search mode of plain query words
+var searchMode string
+
+// searchOptions represents options of search command
+type searchOptions struct {
+	Limit int                // maximum number of shown records, 0 means no limit
+	Page  int                // page number of shown records
+	Query vault.QueryOptions // query parsing options
+}
+
+// helper function to parse search options and return remaining arguments
+func parseSearchOptions(args []string) (searchOptions, []string, error) {
+	opts := searchOptions{Page: 1, Query: vault.QueryOptions{Mode: searchMode}}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, val, hasVal := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") {
+			rest = append(rest, arg)
+			continue
+		}
+		switch name {
+		case "case-sensitive":
+			opts.Query.CaseSensitive = true
+			continue
+		case "all-fields":
+			opts.Query.AllFields = true
+			continue
+		case "include-protected":
+			opts.Query.IncludeProtected = true
+			continue
+		case "limit", "page", "mode":
+		default:
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasVal {
+			if i+1 == len(args) {
+				return opts, rest, fmt.Errorf("%w, missing value of %s option", errUsage, arg)
+			}
+			i++
+			val = args[i]
+		}
+		if name == "mode" {
+			if err := vault.CheckMode(val); err != nil {
+				return opts, rest, fmt.Errorf("%w, %v", errUsage, err)
+			}
+			opts.Query.Mode = val
+			continue
+		}
+		num, err := strconv.Atoi(val)
+		if err != nil || num < 1 {
+			return opts, rest, fmt.Errorf("%w, %s option requires positive number", errUsage, arg)
+		}
+		if name == "limit" {
+			opts.Limit = num
+		} else {
+			opts.Page = num
+		}
+	}
+	return opts, rest, nil
+}
+
+// searchValueOptions lists options of search command which require value
+var searchValueOptions = []string{"save", "limit", "page", "mode", "format"}
+
+// cmdSearch implements search command, it searches across all opened
+// databases and prints matched records sorted by their relevance, the
+// query can be saved in active database with --save option
+func cmdSearch(s *session, args []string) error {
+	name, sopts, opts, query, err := parseSearchArgs(args, s.raw)
+	if err != nil {
+		return err
+	}
+	q, err := vault.ParseQuery(query, sopts.Query)
+	if err != nil {
+		return fmt.Errorf("%w, %v", errUsage, err)
+	}
+	if name != "" {
+		search := vault.SavedSearch{Name: name, Query: query, Options: sopts.Query}
+		if err := s.active.vault.SaveSearch(search); err != nil {
+			return fmt.Errorf("%w, %v", errUsage, err)
+		}
+		if err := s.active.update(); err != nil {
+			return err
+		}
+		log.Printf("saved search '%s'", name)
+	}
+	return s.showMatches(query, sopts, opts, func(d *kdb) ([]vault.Match, error) {
+		return d.vault.Find(q), nil
+	})
+}
+
+// helper function to parse arguments of search command, it returns name of
+// saved search, search and output options and the query. If raw arguments
+// are given, i.e. arguments typed in interactive session or script, query
+// words are taken from them since quotes and escapes are part of query
+// syntax, while option values are used unquoted.
+func parseSearchArgs(args, raw []string) (string, searchOptions, outputOptions, string, error) {
+	var name string
+	var sopts searchOptions
+	var opts outputOptions
+	if len(raw) == len(args) {
+		args = append([]string{}, args...)
+		for i := 0; i < len(args); i++ {
+			if !strings.HasPrefix(args[i], "-") {
+				args[i] = raw[i]
+				continue
+			}
+			opt, _, hasVal := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+			if !hasVal && inList(opt, searchValueOptions) {
+				i++
+			}
+		}
+	}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		opt, val, hasVal := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || opt != "save" {
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasVal {
+			if i+1 == len(args) {
+				return name, sopts, opts, "", fmt.Errorf("%w, missing value of %s option", errUsage, arg)
+			}
+			i++
+			val = args[i]
+		}
+		name = val
+	}
+	sopts, args, err := parseSearchOptions(rest)
+	if err != nil {
+		return name, sopts, opts, "", err
+	}
+	opts, args, err = parseOptions(args)
+	if err != nil {
+		return name, sopts, opts, "", err
+	}
+	return name, sopts, opts, strings.Join(args, " "), nil
+}
+
+// helper function to show matches of all opened databases sorted by their
+// relevance, matches are returned by given find function
+func (s *session) showMatches(query string, sopts searchOptions, opts outputOptions, find func(*kdb) ([]vault.Match, error)) error {
+	var hits []searchHit
+	for _, d := range s.dbs {
+		matches, err := find(d)
+		if err != nil {
+			return err
+		}
+		for _, m := range matches {
+			hits = append(hits, searchHit{db: d, match: m})
+		}
+	}
+	if len(hits) == 0 {
+		return fmt.Errorf("%w: %s", errNotFound, query)
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].match.Score > hits[j].match.Score
+	})
+	total := len(hits)
+	if limit, page := sopts.Limit, sopts.Page; limit > 0 {
+		start := (page - 1) * limit
+		if start >= total {
+			return fmt.Errorf("%w: page %d of '%s' results, there are %d matches", errNotFound, page, query, total)
+		}
+		hits = hits[start:]
+		if len(hits) > limit {
+			hits = hits[:limit]
+		}
+		defer log.Printf("shown %d-%d of %d matches", start+1, start+len(hits), total)
+	}
+	var records []RecordInfo
+	for _, hit := range hits {
+		rid := hit.match.ID
+		if opts.Format == "" {
+			entry, _ := hit.db.vault.Entry(rid)
+			hit.db.printMatch(hit.match, entry)
+			continue
+		}
+		recs, err := hit.db.collectRecords([]int{rid}, opts.Reveal)
+		if err != nil {
+			return err
+		}
+		for i := range recs {
+			recs[i].Matched = matchedFields(hit.match)
+		}
+		records = append(records, recs...)
+	}
+	if opts.Format != "" {
+		return writeRecords(records, opts.Format)
+	}
+	return nil
+}
+
+// cmdSaved implements saved command, it lists saved searches of opened
+// databases or removes given one
+func cmdSaved(s *session, args []string) error {
+	if len(args) > 0 {
+		if args[0] != "rm" || len(args) != 2 {
+			return fmt.Errorf("%w, usage: saved [rm <name>]", errUsage)
+		}
+		d, name := s.resolve(args[1])
+		if err := d.vault.DeleteSearch(name); err != nil {
+			return err
+		}
+		return d.update()
+	}
+	found := false
+	for _, d := range s.dbs {
+		for _, search := range d.vault.SavedSearches() {
+			found = true
+			var flags []string
+			if search.Options.Mode != "" {
+				flags = append(flags, "--mode "+search.Options.Mode)
+			}
+			if search.Options.CaseSensitive {
+				flags = append(flags, "--case-sensitive")
+			}
+			if search.Options.AllFields {
+				flags = append(flags, "--all-fields")
+			}
+			if search.Options.IncludeProtected {
+				flags = append(flags, "--include-protected")
+			}
+			fmt.Println(strings.TrimSpace(fmt.Sprintf("%s%-16s %s %s", d.prefix, search.Name, search.Query, strings.Join(flags, " "))))
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: no saved searches", errNotFound)
+	}
+	return nil
+}
+
+// cmdRun implements run command, it runs saved search across all opened
+// databases, the name can be prefixed by database alias, e.g. team:oncall
+func cmdRun(s *session, args []string) error {
+	sopts, args, err := parseSearchOptions(args)
+	if err != nil {
+		return err
+	}
+	opts, args, err := parseOptions(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("%w, usage: run <name>", errUsage)
+	}
+	d, name := s.resolve(args[0])
+	search, err := d.vault.SavedSearch(name)
+	if err != nil {
+		return err
+	}
+	q, err := search.Parse()
+	if err != nil {
+		return fmt.Errorf("%w, %v", errUsage, err)
+	}
+	return s.showMatches(search.Query, sopts, opts, func(d *kdb) ([]vault.Match, error) {
+		return d.vault.Find(q), nil
+	})
+}
+
+// cmdURL implements url command, it finds records matching URL of a login
+// page across all opened databases
+func cmdURL(s *session, args []string) error {
+	var uopts vault.URLOptions
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, val, hasVal := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		switch {
+		case !strings.HasPrefix(arg, "-"):
+			rest = append(rest, arg)
+		case name == "strict":
+			uopts.Strict = true
+		case name == "match":
+			if !hasVal {
+				if i+1 == len(args) {
+					return fmt.Errorf("%w, missing value of %s option", errUsage, arg)
+				}
+				i++
+				val = args[i]
+			}
+			if err := vault.CheckURLMode(val); err != nil {
+				return fmt.Errorf("%w, %v", errUsage, err)
+			}
+			uopts.Match = val
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	sopts, rest, err := parseSearchOptions(rest)
+	if err != nil {
+		return err
+	}
+	opts, rest, err := parseOptions(rest)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return fmt.Errorf("%w, usage: url <URL>", errUsage)
+	}
+	return s.showMatches(rest[0], sopts, opts, func(d *kdb) ([]vault.Match, error) {
+		matches, err := d.vault.FindURL(rest[0], uopts)
+		if err != nil {
+			return nil, fmt.Errorf("%w, %v", errUsage, err)
+		}
+		return matches, nil
+	})
+}
+
+// cmdList implements ls command
+func cmdList(s *session, args []string) error {
+	opts, args, err := parseOptions(args)
+	if err != nil {
+		return err
+	}
+	group := strings.Join(args, " ")
+	if s.active.listRecords(group, opts) == 0 {
+		return fmt.Errorf("%w: no records in '%s' group", errNotFound, group)
+	}
+	return nil
+}
+
+// cmdRecent implements recent command, it lists most recently used records
+// of active database
+func cmdRecent(s *session, args []string) error {
+	opts, args, err := parseOptions(args)
+	if err != nil {
+		return err
+	}
+	limit := 10
+	if len(args) > 0 {
+		if limit, err = strconv.Atoi(args[0]); err != nil || limit < 1 {
+			return fmt.Errorf("%w, number of records should be positive number", errUsage)
+		}
+	}
+	d := s.active
+	rids := d.vault.Recent()
+	if len(rids) == 0 {
+		return fmt.Errorf("%w: no recently used records", errNotFound)
+	}
+	if len(rids) > limit {
+		rids = rids[:limit]
+	}
+	return d.printRecords(rids, opts, func(rid int, entry gokeepasslib.Entry) {
+		fmt.Printf("%-4s %s %4dx %s/%s\n", d.label(rid),
+			entry.Times.LastAccessTime.Time.Local().Format("2006-01-02 15:04"),
+			entry.Times.UsageCount, d.vault.Group(rid), entry.GetTitle())
+	})
+}
+
+// cmdFavorite implements fav command, it marks given record as favorite or
+// lists favorite records of active database
+func cmdFavorite(s *session, args []string) error {
+	opts, args, err := parseOptions(args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		d, key := s.resolve(args[0])
+		if err := d.vault.SetFavorite(key, true); err != nil {
+			return err
+		}
+		return d.update()
+	}
+	d := s.active
+	rids := d.vault.Favorites()
+	if len(rids) == 0 {
+		return fmt.Errorf("%w: no favorite records", errNotFound)
+	}
+	return d.printRecords(rids, opts, func(rid int, entry gokeepasslib.Entry) {
+		fmt.Printf("%-4s %s/%s\n", d.label(rid), d.vault.Group(rid), entry.GetTitle())
+	})
+}
+
+// cmdUnfavorite implements unfav command
+func cmdUnfavorite(s *session, args []string) error {
+	d, key := s.resolve(args[0])
+	if err := d.vault.SetFavorite(key, false); err != nil {
+		return err
+	}
+	return d.update()
+}
+
+// cmdShow implements show command
+func cmdShow(s *session, args []string) error {
+	opts, args, err := parseOptions(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("%w, usage: show <ID>", errUsage)
+	}
+	d, key := s.resolve(args[0])
+	return d.showRecord(key, opts)
+}
+
+// cmdGet implements get command
+func cmdGet(s *session, args []string) error {
+	opts, args, err := parseOptions(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("%w, usage: get <ID> [field]", errUsage)
+	}
+	field := "password"
+	if len(args) > 1 {
+		field = args[1]
+	}
+	d, key := s.resolve(args[0])
+	return d.getRecord(key, field, opts)
+}
+
+// cmdCopy implements cp command
+func cmdCopy(s *session, args []string) error {
+	attr := "password"
+	if len(args) > 1 {
+		attr = args[1]
+	}
+	d, key := s.resolve(args[0])
+	if err := d.clipboardCopy(key, attr); err != nil {
+		return err
+	}
+	fmt.Printf("%s copied to clipboard\n", attr)
+	return nil
+}
+
+// cmdAdd implements add command, in interactive session a single key
+// argument starts collecting its value, otherwise key=value pairs are
+// stored as new record
+func cmdAdd(s *session, args []string) error {
+	d := s.active
+	if s.interactive && len(args) == 1 && !strings.Contains(args[0], "=") {
+		if d.rec == nil {
+			d.rec = make(vault.Record)
+		}
+		d.collectKey = args[0]
+		if strings.ToLower(d.collectKey) == "password" {
+			fmt.Println("set encrypted input for password field")
+		}
+		return nil
+	}
+	rec := parseRecord(args)
+	if len(rec) == 0 {
+		return fmt.Errorf("%w, usage: add <key=value> ...", errUsage)
+	}
+	for key, val := range rec {
+		if strings.ToLower(key) == "password" && val == "-" {
+			pwd1 := readPassword("password value: ")
+			pwd2 := readPassword("repeat password: ")
+			match := bytes.Equal(pwd1, pwd2)
+			// password buffer is wiped once the record is saved
+			rec[key] = vault.SecretString(pwd1)
+			defer vault.Wipe(pwd1)
+			vault.Wipe(pwd2)
+			if !match {
+				return errors.New("password match failed")
+			}
+		}
+	}
+	return d.saveRecord(rec)
+}
+
+// cmdEdit implements edit command
+func cmdEdit(s *session, args []string) error {
+	d, key := s.resolve(args[0])
+	return d.editRecord(key, parseRecord(args[1:]))
+}
+
+// cmdRemove implements rm command
+func cmdRemove(s *session, args []string) error {
+	d, key := s.resolve(args[0])
+	return d.removeRecord(key)
+}
+
+// cmdSave implements save command
+func cmdSave(s *session, args []string) error {
+	d := s.active
+	rec := d.rec
+	defer d.discardRecord()
+	if err := d.saveRecord(rec); err != nil {
+		return fmt.Errorf("unable to save record, %v", err)
+	}
+	return nil
+}
+
+// cmdTimeout implements timeout command
+func cmdTimeout(s *session, args []string) error {
+	if len(args) == 0 {
+		fmt.Println("Current DB timeout is", s.timeout)
+		return nil
+	}
+	val, err := strconv.Atoi(args[0])
+	if err != nil || val <= 0 {
+		return fmt.Errorf("%w, timeout should be positive number of seconds", errUsage)
+	}
+	s.timeout = time.Duration(val) * time.Second
+	fmt.Println("New DB timeout is set to", s.timeout)
+	return nil
+}
+
+// cmdOpen implements open command
+func cmdOpen(s *session, args []string) error {
+	var kfile string
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--kfile" || args[i] == "-kfile" {
+			if i+1 == len(args) {
+				return fmt.Errorf("%w, missing value of %s option", errUsage, args[i])
+			}
+			i++
+			kfile = expandPath(args[i])
+		} else {
+			rest = append(rest, args[i])
+		}
+	}
+	if len(rest) == 0 {
+		return fmt.Errorf("%w, usage: open <path> [alias]", errUsage)
+	}
+	d, err := openDB(expandPath(rest[0]), kfile, promptPassword)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 1 {
+		d.name = rest[1]
+	}
+	s.add(d)
+	fmt.Printf("Opened %s as %s (%d records)\n", d.vault.Path(), d.name, d.vault.Len())
+	return nil
+}
+
+// cmdUse implements use command
+func cmdUse(s *session, args []string) error {
+	d := s.find(args[0])
+	if d == nil {
+		return fmt.Errorf("database %s is not opened", args[0])
+	}
+	s.active = d
+	return nil
+}
+
+// cmdClose implements close command
+func cmdClose(s *session, args []string) error {
+	d := s.find(args[0])
+	if d == nil {
+		return fmt.Errorf("database %s is not opened", args[0])
+	}
+	force := len(args) > 1 && (args[1] == "--force" || args[1] == "-force")
+	if len(d.rec) != 0 && !force {
+		return fmt.Errorf("database %s has unsaved record, use save or close %s --force", d.name, d.name)
+	}
+	if d.vault.Dirty() && !force {
+		return fmt.Errorf("database %s has uncommitted changes, use close %s --force to discard them", d.name, d.name)
+	}
+	if len(s.dbs) == 1 {
+		return errors.New("unable to close last opened database, use exit instead")
+	}
+	var dbs []*kdb
+	for _, db := range s.dbs {
+		if db != d {
+			dbs = append(dbs, db)
+		}
+	}
+	s.dbs = dbs
+	if s.active == d {
+		s.active = dbs[0]
+	}
+	s.updatePrefixes()
+	d.lock()
+	d.discardRecord()
+	return nil
+}
+
+// cmdDatabases implements dbs command
+func cmdDatabases(s *session, args []string) error {
+	for _, d := range s.dbs {
+		active := " "
+		if d == s.active {
+			active = "*"
+		}
+		state := ""
+		if len(d.rec) != 0 {
+			state = " (unsaved record)"
+		} else if d.vault.Dirty() {
+			state = " (uncommitted changes)"
+		}
+		fmt.Printf("%s %-12s %s, %d records%s\n", active, d.name, d.vault.Path(), d.vault.Len(), state)
+	}
+	return nil
+}
+
+// cmdEncrypt implements encrypt command
+func cmdEncrypt(s *session, args []string) error {
+	fname, pwdReader, err := parseCryptArgs(args)
+	if err != nil {
+		return err
+	}
+	return encryptFile(fname, s.kfile, s.cipher, pwdReader)
+}
+
+// cmdDecrypt implements decrypt command
+func cmdDecrypt(s *session, args []string) error {
+	fname, pwdReader, err := parseCryptArgs(args)
+	if err != nil {
+		return err
+	}
+	return decryptFile(fname, s.kfile, s.cipher, pwdReader)
+}
+
+// helper function to parse arguments of encrypt and decrypt commands, the
+// file password is asked in the terminal unless --password-file option is
+// given, the master password source is not used since it can be read only
+// once and it is not the password of the file
+func parseCryptArgs(args []string) (string, func(string) ([]byte, error), error) {
+	var fname string
+	src := PasswordSource{Fd: -1}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, val, hasVal := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		switch {
+		case !strings.HasPrefix(arg, "-") && fname == "":
+			fname = arg
+		case name == "password-file":
+			if !hasVal {
+				if i+1 == len(args) {
+					return "", nil, fmt.Errorf("%w, missing value of %s option", errUsage, arg)
+				}
+				i++
+				val = args[i]
+			}
+			src.File = expandPath(val)
+		default:
+			return "", nil, fmt.Errorf("%w, unsupported argument %s", errUsage, arg)
+		}
+	}
+	if fname == "" {
+		return "", nil, fmt.Errorf("%w, missing file name", errUsage)
+	}
+	if src.File == "" && !terminal.IsTerminal(int(syscall.Stdin)) {
+		return "", nil, fmt.Errorf("%w, stdin is not a terminal, use --password-file option", errUsage)
+	}
+	return fname, src.read, nil
+}
+
+// cmdHelp implements help command
+func cmdHelp(s *session, args []string) error {
+	cmdUsage(s.active.vault.Path())
+	return nil
+}
+
+// cmdExit implements exit command
+func cmdExit(s *session, args []string) error {
+	if s.script {
+		return errStop
+	}
+	exit(0)
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"testing"
+
+	"github.com/vkuznet/kpass/vault"
+)
+
+func TestSearchArgs(t *testing.T) {
+	tests := []struct {
+		line  string   // arguments typed in interactive session or script
+		args  []string // the same arguments given on the command line
+		query string
+		name  string
+		limit int
+	}{
+		{line: `title:"db prod"`, args: []string{`title:"db prod"`}, query: `title:"db prod"`},
+		{line: `notes:/a\d/`, args: []string{`notes:/a\d/`}, query: `notes:/a\d/`},
+		{line: `prod NOT url:""`, args: []string{`prod NOT url:""`}, query: `prod NOT url:""`},
+		{line: `"c++" OR 'x y'`, args: []string{`"c++" OR 'x y'`}, query: `"c++" OR 'x y'`},
+		{line: `--limit 5 --mode literal title:"db prod" --format json`,
+			args:  []string{"--limit", "5", "--mode", "literal", `title:"db prod"`, "--format", "json"},
+			query: `title:"db prod"`, limit: 5},
+		{line: `--save "on call" tag:oncall`, args: []string{"--save", "on call", "tag:oncall"},
+			query: "tag:oncall", name: "on call"},
+		{line: `--save=oncall tag:"on call"`, args: []string{"--save=oncall", `tag:"on call"`},
+			query: `tag:"on call"`, name: "oncall"},
+	}
+	for _, test := range tests {
+		tokens, raw, err := tokenizeRaw("search " + test.line)
+		if err != nil {
+			t.Fatalf("%s: %v", test.line, err)
+		}
+		sc := &script{vars: make(map[string]string)}
+		stokens, sraw, err := sc.tokenize("search " + test.line)
+		if err != nil {
+			t.Fatalf("%s: %v", test.line, err)
+		}
+		inputs := map[string][2][]string{
+			"interactive": {tokens[1:], raw[1:]},
+			"script":      {stokens[1:], sraw[1:]},
+			"command":     {test.args, nil},
+		}
+		for mode, input := range inputs {
+			name, sopts, opts, query, err := parseSearchArgs(input[0], input[1])
+			if err != nil {
+				t.Errorf("%s %s: %v", mode, test.line, err)
+				continue
+			}
+			if query != test.query || name != test.name || sopts.Limit != test.limit {
+				t.Errorf("%s %s: got query %q, name %q, limit %d, expected %q, %q, %d",
+					mode, test.line, query, name, sopts.Limit, test.query, test.name, test.limit)
+			}
+			if _, err := vault.ParseQuery(query, sopts.Query); err != nil {
+				t.Errorf("%s %s: %v", mode, test.line, err)
+			}
+			if opts.Format != outputFormat && opts.Format != "json" {
+				t.Errorf("%s %s: unexpected format %q", mode, test.line, opts.Format)
+			}
+		}
+	}
+}
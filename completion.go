@@ -0,0 +1,339 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vkuznet/kpass/vault"
+)
+
+// completionShells lists shells supported by completion command
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// flagInfo represents command line flag used in completion scripts
+type flagInfo struct {
+	Name  string // flag name
+	Usage string // flag description
+	Value bool   // flag requires value
+}
+
+// helper function to return command line flags
+func completionFlags() []flagInfo {
+	var flags []flagInfo
+	flag.VisitAll(func(f *flag.Flag) {
+		value := true
+		if bf, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && bf.IsBoolFlag() {
+			value = false
+		}
+		flags = append(flags, flagInfo{Name: f.Name, Usage: f.Usage, Value: value})
+	})
+	return flags
+}
+
+// helper function to return non-interactive commands along with their help
+func completionCommands() [][2]string {
+	var cmds [][2]string
+	for _, cmd := range commandTable {
+		if cmd.Batch {
+			cmds = append(cmds, [2]string{cmd.Name, cmd.Help})
+		}
+	}
+	cmds = append(cmds, [2]string{"completion", "print shell completion script (bash, zsh, fish)"})
+	cmds = append(cmds, [2]string{"tui", "full-screen terminal UI"})
+	return cmds
+}
+
+// fishArgs defines arguments completion of fish flags
+var fishArgs = map[string]string{
+	"kdbx":          "-r -F",
+	"kfile":         "-r -F",
+	"config":        "-r -F",
+	"script":        "-r -F",
+	"password-file": "-r -F",
+	"decrypt":       "-r -F",
+	"encrypt":       "-r -F",
+	"format":        "-x -a '@FORMATS@'",
+	"cipher":        "-x -a 'aes nacl'",
+	"search-mode":   "-x -a '@MODES@'",
+	"profile":       "-x -a '(kpass __complete profiles 2>/dev/null)'",
+}
+
+// helper function to print shell completion script of given shell
+func printCompletion(shell string) error {
+	var names, values, zshFlags, zshCmds, fishFlags, fishCmds []string
+	// lists of option values used by fish arguments and completion scripts
+	lists := strings.NewReplacer(
+		"@FORMATS@", strings.Join(outputFormats, " "),
+		"@MODES@", strings.Join(vault.Modes, " "),
+	)
+	for _, f := range completionFlags() {
+		names = append(names, "-"+f.Name)
+		if f.Value {
+			values = append(values, f.Name)
+		}
+		usage := strings.Split(f.Usage, ". ")[0]
+		zshFlags = append(zshFlags, fmt.Sprintf("'-%s:%s'", f.Name, zshEscape(usage)))
+		fishFlag := fmt.Sprintf("complete -c kpass -n '__kpass_no_cmd' -o %s -d '%s'", f.Name, fishEscape(usage))
+		if arg, ok := fishArgs[f.Name]; ok {
+			fishFlag += " " + lists.Replace(arg)
+		} else if f.Value {
+			fishFlag += " -x"
+		}
+		fishFlags = append(fishFlags, fishFlag)
+	}
+	var cmds []string
+	for _, cmd := range completionCommands() {
+		cmds = append(cmds, cmd[0])
+		zshCmds = append(zshCmds, fmt.Sprintf("'%s:%s'", cmd[0], zshEscape(cmd[1])))
+		fishCmds = append(fishCmds, fmt.Sprintf("complete -c kpass -n '__kpass_no_cmd' -f -a %s -d '%s'", cmd[0], fishEscape(cmd[1])))
+	}
+	var script string
+	switch shell {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	case "fish":
+		script = fishCompletion
+	default:
+		return fmt.Errorf("%w, unsupported shell '%s', supported shells: %s",
+			errUsage, shell, strings.Join(completionShells, ","))
+	}
+	r := strings.NewReplacer(
+		"@FLAGS@", strings.Join(names, " "),
+		"@VALUE_FLAGS@", strings.Join(values, " "),
+		"@COMMANDS@", strings.Join(cmds, " "),
+		"@FORMATS@", strings.Join(outputFormats, " "),
+		"@MODES@", strings.Join(vault.Modes, " "),
+		"@SHELLS@", strings.Join(completionShells, " "),
+		"@ZSH_FLAGS@", strings.Join(zshFlags, "\n    "),
+		"@ZSH_COMMANDS@", strings.Join(zshCmds, "\n    "),
+		"@FISH_FLAGS@", strings.Join(fishFlags, "\n"),
+		"@FISH_COMMANDS@", strings.Join(fishCmds, "\n"),
+	)
+	fmt.Print(r.Replace(script))
+	return nil
+}
+
+// helper function to escape zsh completion description
+func zshEscape(val string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(val, ":", `\:`), "'", `'\''`)
+}
+
+// helper function to escape fish completion description
+func fishEscape(val string) string {
+	return strings.ReplaceAll(val, "'", `\'`)
+}
+
+// helper function to print dynamic completion candidates used by completion
+// scripts. Only candidates which do not require access to the database are
+// provided, completion never decrypts the database.
+func printCandidates(cfg Config, what string) {
+	if what != "profiles" {
+		return
+	}
+	var names []string
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
+
+// bashCompletion represents bash completion script
+var bashCompletion = `# bash completion for kpass, load it via
+#   source <(kpass completion bash)
+__kpass_value_flags=" @VALUE_FLAGS@ "
+
+_kpass() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    local prev="${COMP_WORDS[COMP_CWORD-1]}"
+    case "${prev#-}" in
+        -kdbx|kdbx)
+            COMPREPLY=( $(compgen -f -X '!*.kdbx' -- "$cur") $(compgen -d -- "$cur") )
+            compopt -o filenames 2>/dev/null
+            return ;;
+        -kfile|kfile|-config|config|-script|script|-password-file|password-file|-decrypt|decrypt|-encrypt|encrypt)
+            COMPREPLY=( $(compgen -f -- "$cur") )
+            compopt -o filenames 2>/dev/null
+            return ;;
+        -format|format)
+            COMPREPLY=( $(compgen -W "@FORMATS@" -- "$cur") )
+            return ;;
+        -cipher|cipher)
+            COMPREPLY=( $(compgen -W "aes nacl" -- "$cur") )
+            return ;;
+        -search-mode|search-mode)
+            COMPREPLY=( $(compgen -W "@MODES@" -- "$cur") )
+            return ;;
+        -profile|profile)
+            COMPREPLY=( $(compgen -W "$(kpass __complete profiles 2>/dev/null)" -- "$cur") )
+            return ;;
+    esac
+
+    # find sub-command, options given before it and number of its arguments
+    local i w cmd="" nargs=0
+    for (( i=1; i < COMP_CWORD; i++ )); do
+        w="${COMP_WORDS[i]}"
+        if [[ -n "$cmd" ]]; then
+            [[ "$w" != -* ]] && (( nargs++ ))
+        elif [[ "$w" == -* ]]; then
+            local name="${w#-}"
+            name="${name#-}"
+            if [[ "$w" != *=* && "$__kpass_value_flags" == *" $name "* ]]; then
+                (( i++ ))
+            fi
+        else
+            cmd="$w"
+        fi
+    done
+
+    if [[ -z "$cmd" ]]; then
+        if [[ "$cur" == -* ]]; then
+            COMPREPLY=( $(compgen -W "@FLAGS@" -- "$cur") )
+        else
+            COMPREPLY=( $(compgen -W "@COMMANDS@" -- "$cur") )
+        fi
+        return
+    fi
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=( $(compgen -W "--format --reveal" -- "$cur") )
+        return
+    fi
+    local IFS=$'\n' candidates=""
+    case "$cmd" in
+        completion)
+            [[ $nargs -eq 0 ]] && candidates="$(printf '%s\n' @SHELLS@)" ;;
+    esac
+    local c
+    COMPREPLY=()
+    for c in $(compgen -W "$candidates" -- "$cur"); do
+        COMPREPLY+=( "$(printf '%q' "$c")" )
+    done
+}
+complete -F _kpass kpass
+`
+
+// zshCompletion represents zsh completion script
+var zshCompletion = `#compdef kpass
+# zsh completion for kpass, load it via
+#   source <(kpass completion zsh)
+# or place its output as _kpass file into directory listed in $fpath
+
+_kpass() {
+  local -a flags commands value_flags
+  local i w cmd="" nargs=0
+  value_flags=(@VALUE_FLAGS@)
+  flags=(
+    @ZSH_FLAGS@
+  )
+  commands=(
+    @ZSH_COMMANDS@
+  )
+  for (( i=2; i < CURRENT; i++ )); do
+    w=${words[i]}
+    if [[ -n $cmd ]]; then
+      [[ $w != -* ]] && (( nargs++ ))
+    elif [[ $w == -* ]]; then
+      if [[ $w != *=* ]] && (( ${value_flags[(Ie)${w##-#}]} )); then
+        (( i++ ))
+      fi
+    else
+      cmd=$w
+    fi
+  done
+
+  case ${words[CURRENT-1]##-#} in
+    kdbx) _files -g '*.kdbx'; return ;;
+    kfile|config|script|password-file|decrypt|encrypt) _files; return ;;
+    format) compadd @FORMATS@; return ;;
+    cipher) compadd aes nacl; return ;;
+    search-mode) compadd @MODES@; return ;;
+    profile) compadd -- ${(f)"$(kpass __complete profiles 2>/dev/null)"}; return ;;
+  esac
+
+  if [[ -z $cmd ]]; then
+    if [[ $PREFIX == -* ]]; then
+      _describe 'option' flags
+    else
+      _describe 'command' commands
+    fi
+    return
+  fi
+  if [[ $PREFIX == -* ]]; then
+    compadd -- --format --reveal
+    return
+  fi
+  (( nargs > 0 )) && return
+  case $cmd in
+    completion) compadd @SHELLS@ ;;
+  esac
+}
+
+if [[ $funcstack[1] == _kpass ]]; then
+  _kpass "$@"
+else
+  compdef _kpass kpass
+fi
+`
+
+// fishCompletion represents fish completion script
+var fishCompletion = `# fish completion for kpass, load it via
+#   kpass completion fish | source
+# or save it as ~/.config/fish/completions/kpass.fish
+
+set -g __kpass_value_flags @VALUE_FLAGS@
+
+# print sub-command followed by its arguments
+function __kpass_cmd
+    set -l tokens (commandline -opc)
+    set -e tokens[1]
+    set -l skip 0
+    set -l found 0
+    for t in $tokens
+        if test $found -eq 1
+            string match -q -- '-*' $t; or echo $t
+            continue
+        end
+        if test $skip -eq 1
+            set skip 0
+            continue
+        end
+        switch $t
+            case '-*'
+                if not string match -q -- '*=*' $t; and contains -- (string replace -r -- '^-+' '' $t) $__kpass_value_flags
+                    set skip 1
+                end
+            case '*'
+                echo $t
+                set found 1
+        end
+    end
+end
+
+function __kpass_no_cmd
+    test (count (__kpass_cmd)) -eq 0
+end
+
+# check that sub-command is one of given ones and it has no arguments yet
+function __kpass_cmd_first_arg
+    set -l cmd (__kpass_cmd)
+    test (count $cmd) -eq 1; and contains -- $cmd[1] $argv
+end
+
+complete -c kpass -f
+@FISH_FLAGS@
+@FISH_COMMANDS@
+complete -c kpass -n 'not __kpass_no_cmd' -l format -x -a '@FORMATS@'
+complete -c kpass -n 'not __kpass_no_cmd' -l reveal
+complete -c kpass -n '__kpass_cmd_first_arg completion' -a '@SHELLS@'
+`
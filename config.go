@@ -0,0 +1,183 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile represents named set of kpass settings
+type Profile struct {
+	Kdbx           string              `yaml:"kdbx"`            // path to kdbx file
+	Kfile          string              `yaml:"kfile"`           // key file name
+	Timeout        int                 `yaml:"timeout"`         // inactivity timeout in seconds
+	ClipboardClear int                 `yaml:"clipboard_clear"` // clipboard clear delay in seconds
+	Format         string              `yaml:"format"`          // output format
+	Cipher         string              `yaml:"cipher"`          // default cipher
+	SearchMode     string              `yaml:"search_mode"`     // default search mode
+	PasswordFile   string              `yaml:"password_file"`   // file with master password
+	PasswordCmd    string              `yaml:"password_cmd"`    // command printing master password
+	Hooks          map[string]Commands `yaml:"hooks"`           // hook commands of database events
+}
+
+// Config represents kpass configuration file
+type Config struct {
+	Default  string              `yaml:"default"`  // default profile name
+	Profiles map[string]Profile  `yaml:"profiles"` // named profiles
+	Hooks    map[string]Commands `yaml:"hooks"`    // hook commands used by all profiles
+}
+
+// helper function to return default location of configuration file
+func configPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, ".config")
+		}
+	}
+	return filepath.Join(dir, "kpass", "config.yaml")
+}
+
+// helper function to load configuration file, missing file is not an error
+func loadConfig(fname string) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return config, nil
+		}
+		return config, err
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("unable to parse %s, %v", fname, err)
+	}
+	return config, nil
+}
+
+// helper function to expand home directory in given path
+func expandPath(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
+
+// helper function to return profile with given name, empty name refers to
+// default profile of configuration
+func (c *Config) profile(name string) (Profile, error) {
+	if name == "" {
+		name = c.Default
+	}
+	if name == "" {
+		return Profile{}, nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		return p, fmt.Errorf("profile '%s' not found in configuration", name)
+	}
+	return p, nil
+}
+
+// helper function to return environment variable name of given flag
+func envName(name string) string {
+	return "KPASS_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// helper function to look up setting which can be provided either via
+// command line flag of given flag set or KPASS_* environment variable
+func lookupSetting(flags *flag.FlagSet, name, value string) string {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	if set {
+		return value
+	}
+	if val, ok := os.LookupEnv(envName(name)); ok {
+		return val
+	}
+	return value
+}
+
+// passwordSettings lists settings of master password sources
+var passwordSettings = []string{"password-file", "password-fd", "password-env", "password-cmd"}
+
+// helper function to apply configuration settings to command line flags of
+// given flag set, the precedence is flag > environment variable > profile >
+// default
+func applyConfig(flags *flag.FlagSet, profile Profile) error {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	itoa := func(val int) string {
+		if val == 0 {
+			return ""
+		}
+		return strconv.Itoa(val)
+	}
+	settings := []struct {
+		name  string
+		value string
+	}{
+		{"kdbx", expandPath(profile.Kdbx)},
+		{"kfile", expandPath(profile.Kfile)},
+		{"interval", itoa(profile.Timeout)},
+		{"clipboard-clear", itoa(profile.ClipboardClear)},
+		{"format", profile.Format},
+		{"cipher", profile.Cipher},
+		{"search-mode", profile.SearchMode},
+		{"password-file", expandPath(profile.PasswordFile)},
+		{"password-fd", ""},
+		{"password-env", ""},
+		{"password-cmd", profile.PasswordCmd},
+	}
+	// password sources are exclusive, therefore source given by flag
+	// overrides all other sources and source given by environment overrides
+	// sources of profile
+	pwdFlag, pwdEnv := false, false
+	for _, name := range passwordSettings {
+		if set[name] {
+			pwdFlag = true
+		}
+		if _, ok := os.LookupEnv(envName(name)); ok {
+			pwdEnv = true
+		}
+	}
+	for _, s := range settings {
+		pwdSetting := inList(s.name, passwordSettings)
+		if set[s.name] || (pwdSetting && pwdFlag) {
+			continue
+		}
+		val, ok := os.LookupEnv(envName(s.name))
+		if ok {
+			val = expandPath(val)
+		} else if pwdSetting && pwdEnv {
+			continue
+		} else {
+			val = s.value
+		}
+		if val == "" {
+			continue
+		}
+		if err := flags.Set(s.name, val); err != nil {
+			return fmt.Errorf("invalid value '%s' of %s setting, %v", val, s.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// helper function to create flag set with configurable kpass options
+func configFlags() *flag.FlagSet {
+	flags := flag.NewFlagSet("kpass", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.String("kdbx", "/home/.keepass.kdbx", "")
+	flags.String("kfile", "", "")
+	flags.Int("interval", 30, "")
+	flags.Int("clipboard-clear", 0, "")
+	flags.String("format", "", "")
+	flags.String("cipher", "aes", "")
+	flags.String("search-mode", "literal", "")
+	flags.String("password-file", "", "")
+	flags.Int("password-fd", -1, "")
+	flags.String("password-env", "", "")
+	flags.String("password-cmd", "", "")
+	flags.String("profile", "", "")
+	return flags
+}
+
+func TestApplyConfig(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip(err)
+	}
+	profile := Profile{Kdbx: "/profile.kdbx", Timeout: 60, Format: "yaml",
+		SearchMode: "fuzzy", PasswordFile: "~/pw"}
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		profile Profile
+		want    map[string]string
+		fail    bool
+	}{
+		{name: "defaults", want: map[string]string{"kdbx": "/home/.keepass.kdbx",
+			"interval": "30", "format": "", "password-file": "", "password-fd": "-1"}},
+		{name: "profile", profile: profile, want: map[string]string{"kdbx": "/profile.kdbx",
+			"interval": "60", "format": "yaml", "search-mode": "fuzzy", "cipher": "aes",
+			"password-file": filepath.Join(home, "pw")}},
+		{name: "env over profile", profile: profile,
+			env:  map[string]string{"KPASS_KDBX": "~/env.kdbx", "KPASS_INTERVAL": "90"},
+			want: map[string]string{"kdbx": filepath.Join(home, "env.kdbx"), "interval": "90", "format": "yaml"}},
+		{name: "flag over env and profile", profile: profile,
+			args: []string{"-kdbx", "/flag.kdbx", "-format=json"},
+			env:  map[string]string{"KPASS_KDBX": "/env.kdbx", "KPASS_FORMAT": "table"},
+			want: map[string]string{"kdbx": "/flag.kdbx", "format": "json", "interval": "60"}},
+		{name: "password source of env over profile", profile: profile,
+			env:  map[string]string{"KPASS_PASSWORD_CMD": "pass show kp"},
+			want: map[string]string{"password-cmd": "pass show kp", "password-file": ""}},
+		{name: "password source of flag over env and profile", profile: profile,
+			args: []string{"-password-fd", "3"},
+			env:  map[string]string{"KPASS_PASSWORD_CMD": "pass show kp"},
+			want: map[string]string{"password-fd": "3", "password-cmd": "", "password-file": ""}},
+		{name: "invalid env value", env: map[string]string{"KPASS_INTERVAL": "soon"}, fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := configFlags()
+			// settings of test environment are restored once test is done
+			flags.VisitAll(func(f *flag.Flag) {
+				t.Setenv(envName(f.Name), "")
+				os.Unsetenv(envName(f.Name))
+			})
+			for key, val := range tt.env {
+				t.Setenv(key, val)
+			}
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			err := applyConfig(flags, tt.profile)
+			if tt.fail {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.want {
+				if got := flags.Lookup(name).Value.String(); got != want {
+					t.Errorf("%s: got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLookupSetting(t *testing.T) {
+	t.Setenv("KPASS_PROFILE", "team")
+	flags := configFlags()
+	if err := flags.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := lookupSetting(flags, "profile", ""); got != "team" {
+		t.Errorf("profile of environment: got %q, want %q", got, "team")
+	}
+	flags = configFlags()
+	if err := flags.Parse([]string{"-profile", "personal"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := lookupSetting(flags, "profile", "personal"); got != "personal" {
+		t.Errorf("profile of flag: got %q, want %q", got, "personal")
+	}
+}
+
+func TestConfigProfile(t *testing.T) {
+	cfg := Config{Default: "personal", Profiles: map[string]Profile{
+		"personal": {Kdbx: "/personal.kdbx"},
+		"team":     {Kdbx: "/team.kdbx"},
+	}}
+	tests := []struct {
+		name string
+		kdbx string
+		fail bool
+	}{
+		{name: "", kdbx: "/personal.kdbx"},
+		{name: "team", kdbx: "/team.kdbx"},
+		{name: "other", fail: true},
+	}
+	for _, tt := range tests {
+		p, err := cfg.profile(tt.name)
+		if (err != nil) != tt.fail || p.Kdbx != tt.kdbx {
+			t.Errorf("profile %q: got %+v, %v", tt.name, p, err)
+		}
+	}
+	if p, err := (&Config{}).profile(""); err != nil || p.Kdbx != "" {
+		t.Errorf("empty configuration: got %+v, %v", p, err)
+	}
+}
@@ -13,14 +13,15 @@ import (
 	"strings"
 
 	"github.com/vkuznet/cryptoutils"
+	"github.com/vkuznet/kpass/vault"
 )
 
 // helper function to generate password
-func genPassword(pwd string) {
+func genPassword(pwd string) error {
 	arr := strings.Split(pwd, ":")
 	i, e := strconv.Atoi(arr[0])
-	if e != nil {
-		log.Fatal(e)
+	if e != nil || i <= 0 {
+		return fmt.Errorf("%w, password length should be positive number", errUsage)
 	}
 	var numbers, symbols bool
 	if strings.Contains(pwd, "n") {
@@ -30,57 +31,67 @@ func genPassword(pwd string) {
 		symbols = true
 	}
 	p := cryptoutils.CreatePassword(i, numbers, symbols)
-	copy2clipboard(p, fmt.Sprintf("New password %s copied to clipboard", p))
+	return copy2clipboard(p, fmt.Sprintf("New password %s copied to clipboard", p))
 }
 
-// helper function to encrypt or decrypt given file
-func cryptFile(fname, kfile, cipher, action string) {
-	password := readPassword("Enter password: ")
+// helper function to encrypt or decrypt given file, the password is obtained
+// from given pwdReader function
+func cryptFile(fname, kfile, cipher, action string, pwdReader func(string) ([]byte, error)) error {
+	if action != "encrypt" && action != "decrypt" {
+		return fmt.Errorf("%w, unsupported action %s", errUsage, action)
+	}
 	data, err := os.ReadFile(fname)
 	if err != nil {
-		log.Fatal(err)
+		return vault.FileError(err)
+	}
+	password, err := pwdReader("Enter password: ")
+	if err != nil {
+		return err
 	}
+	lockMemory(password)
+	defer releaseSecret(password)
 	// if key file is given we'll use KeyFile data value and its hash to
 	// enhance the password
 	if kfile != "" {
-		if keyFile, err := readKeyFile(kfile); err == nil {
-			password = fmt.Sprintf("%s-%s-%s", password, keyFile.Key.Data.Value, keyFile.Key.Data.Hash)
-		} else {
-			log.Fatal(err)
+		keyFile, err := readKeyFile(kfile)
+		if err != nil {
+			return vault.FileError(err)
 		}
+		suffix := fmt.Sprintf("-%s-%s", keyFile.Key.Data.Value, keyFile.Key.Data.Hash)
+		enhanced := make([]byte, 0, len(password)+len(suffix))
+		enhanced = append(append(enhanced, password...), suffix...)
+		lockMemory(enhanced)
+		defer releaseSecret(enhanced)
+		password = enhanced
 	}
 	var oname string
 	if action == "decrypt" {
-		data, err = cryptoutils.Decrypt(data, password, cipher)
+		data, err = cryptoutils.Decrypt(data, string(password), cipher)
+		if err != nil {
+			// decryption fails if either password is wrong or file is damaged
+			return fmt.Errorf("%w, unable to decrypt %s, %v", vault.ErrWrongPassword, fname, err)
+		}
 		oname = fmt.Sprintf("%s-decrypted", fname)
-	} else if action == "encrypt" {
-		data, err = cryptoutils.Encrypt(data, password, cipher)
-		oname = fmt.Sprintf("%s-encrypted", fname)
 	} else {
-		log.Fatalf("unsupported action %s\n", action)
-	}
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = os.WriteFile(oname, data, 0755)
-	if err != nil {
-		log.Fatal("unable to data to output file", err)
+		data, err = cryptoutils.Encrypt(data, string(password), cipher)
+		if err != nil {
+			return err
+		}
+		oname = fmt.Sprintf("%s-encrypted", fname)
 	}
-	if action == "encrypt" {
-		log.Printf("encrypted %s to %s\n", fname, oname)
-	} else if action == "decrypt" {
-		log.Printf("decrypted %s to %s\n", fname, oname)
-	} else {
-		log.Fatalf("unsupported action %s\n", action)
+	if err := os.WriteFile(oname, data, 0755); err != nil {
+		return fmt.Errorf("unable to write output file, %v", err)
 	}
+	log.Printf("%sed %s to %s\n", action, fname, oname)
+	return nil
 }
 
 // encryptFile encrypt given file
-func encryptFile(fname, kfile, cipher string) {
-	cryptFile(fname, kfile, cipher, "encrypt")
+func encryptFile(fname, kfile, cipher string, pwdReader func(string) ([]byte, error)) error {
+	return cryptFile(fname, kfile, cipher, "encrypt", pwdReader)
 }
 
 // decryptFile decrypt given file
-func decryptFile(fname, kfile, cipher string) {
-	cryptFile(fname, kfile, cipher, "decrypt")
+func decryptFile(fname, kfile, cipher string, pwdReader func(string) ([]byte, error)) error {
+	return cryptFile(fname, kfile, cipher, "decrypt", pwdReader)
 }
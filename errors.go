@@ -0,0 +1,83 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/vkuznet/kpass/vault"
+)
+
+// exit codes of kpass
+const (
+	exitOK           = 0 // command succeeded
+	exitError        = 1 // command failed
+	exitUsage        = 2 // wrong command usage
+	exitNotFound     = 3 // no records found
+	exitCredentials  = 4 // wrong password or key file
+	exitCorrupt      = 5 // database file is corrupted
+	exitFileNotFound = 6 // database, key or input file does not exist
+	exitClipboard    = 7 // clipboard is not available
+	exitConflict     = 8 // database file was changed by another program
+)
+
+// errNotFound is returned when requested record does not exist
+var errNotFound = vault.ErrNotFound
+
+// errUsage is returned when command is used with wrong arguments
+var errUsage = errors.New("wrong usage")
+
+// errStop is returned by exit command to stop script execution
+var errStop = errors.New("stop")
+
+// errClipboard is returned when clipboard is not available
+var errClipboard = errors.New("clipboard is not available")
+
+// exitCodes maps errors to exit codes, the order defines precedence of
+// wrapped errors
+var exitCodes = []struct {
+	err  error
+	code int
+	info string
+}{
+	{errUsage, exitUsage, "wrong usage"},
+	{errNotFound, exitNotFound, "record not found"},
+	{vault.ErrWrongPassword, exitCredentials, "wrong password or key file"},
+	{vault.ErrCorrupt, exitCorrupt, "corrupted database file"},
+	{vault.ErrFileNotFound, exitFileNotFound, "file not found"},
+	{errClipboard, exitClipboard, "clipboard unavailable"},
+	{vault.ErrWriteConflict, exitConflict, "database was modified by another program"},
+}
+
+// helper function to convert error into exit code
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	for _, e := range exitCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	return exitError
+}
+
+// helper function to print documented exit codes
+func printExitCodes() {
+	fmt.Printf("%d  success\n", exitOK)
+	fmt.Printf("%d  error\n", exitError)
+	for _, e := range exitCodes {
+		fmt.Printf("%d  %s\n", e.code, e.info)
+	}
+}
+
+// helper function to log error and exit with its exit code
+func fatal(err error) {
+	log.Printf("ERROR: %v", err)
+	exit(exitCode(err))
+}
@@ -0,0 +1,264 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	gokeepasslib "github.com/tobischo/gokeepasslib/v3"
+	"github.com/vkuznet/kpass/vault"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// exportFormats lists supported export formats
+var exportFormats = []string{"csv"}
+
+// csvColumns lists columns of CSV export in the same order as KeePassXC
+// writes them, the same file can be imported by KeePassXC and browsers
+var csvColumns = []string{"Group", "Title", "Username", "Password", "URL",
+	"Notes", "TOTP", "Icon", "Last Modified", "Created"}
+
+// exportOptions represents options of export command
+type exportOptions struct {
+	Format  string        // export format
+	Columns []string      // exported columns
+	Group   string        // export records of given group only
+	Query   string        // export records matching given search query only
+	Search  searchOptions // search options of the query
+	Output  string        // output file, stdout if empty
+	Yes     bool          // do not ask confirmation
+}
+
+// helper function to parse export options
+func parseExportOptions(args []string) (exportOptions, error) {
+	opts := exportOptions{Format: "csv", Columns: csvColumns}
+	sopts, args, err := parseSearchOptions(args)
+	if err != nil {
+		return opts, err
+	}
+	opts.Search = sopts
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, val, hasVal := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") {
+			return opts, fmt.Errorf("%w, unexpected argument '%s'", errUsage, arg)
+		}
+		if name == "yes" || name == "y" {
+			opts.Yes = true
+			continue
+		}
+		if !hasVal {
+			if i+1 == len(args) {
+				return opts, fmt.Errorf("%w, missing value of %s option", errUsage, arg)
+			}
+			i++
+			val = args[i]
+		}
+		switch name {
+		case "format":
+			if !inList(val, exportFormats) {
+				return opts, fmt.Errorf("%w, unsupported export format '%s', supported formats: %s",
+					errUsage, val, strings.Join(exportFormats, ","))
+			}
+			opts.Format = val
+		case "columns":
+			opts.Columns = nil
+			for _, col := range strings.Split(val, ",") {
+				column := csvColumn(strings.TrimSpace(col))
+				if column == "" {
+					return opts, fmt.Errorf("%w, unknown column '%s', supported columns: %s",
+						errUsage, col, strings.Join(csvColumns, ","))
+				}
+				opts.Columns = append(opts.Columns, column)
+			}
+		case "group":
+			opts.Group = val
+		case "query":
+			opts.Query = val
+		case "output", "o":
+			opts.Output = expandPath(val)
+		default:
+			return opts, fmt.Errorf("%w, unknown option %s", errUsage, arg)
+		}
+	}
+	return opts, nil
+}
+
+// helper function to return CSV column of given name, names are case
+// insensitive
+func csvColumn(name string) string {
+	for _, col := range csvColumns {
+		if strings.EqualFold(col, name) {
+			return col
+		}
+	}
+	return ""
+}
+
+// helper function to ask user confirmation of given question
+func confirm(msg string) bool {
+	if !terminal.IsTerminal(int(syscall.Stdin)) {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", msg)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// helper function to return IDs of records to export
+func (d *kdb) exportRecords(opts exportOptions) ([]int, error) {
+	rids := d.groupRecords(opts.Group)
+	if opts.Query == "" {
+		return rids, nil
+	}
+	q, err := vault.ParseQuery(opts.Query, opts.Search.Query)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %v", errUsage, err)
+	}
+	matched := make(map[int]bool)
+	for _, m := range d.vault.Find(q) {
+		matched[m.ID] = true
+	}
+	var out []int
+	for _, rid := range rids {
+		if matched[rid] {
+			out = append(out, rid)
+		}
+	}
+	sort.Ints(out)
+	return out, nil
+}
+
+// helper function to return value of given CSV column of a record,
+// protected values are revealed
+func (d *kdb) csvValue(rid int, entry gokeepasslib.Entry, column string) (string, error) {
+	timeValue := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	reveal := func(keys ...string) (string, error) {
+		for _, key := range keys {
+			if entry.Get(key) == nil {
+				continue
+			}
+			val, err := d.vault.Reveal(entry, key)
+			if err != nil {
+				return "", fmt.Errorf("unable to read %s of %s, %v", key, d.label(rid), err)
+			}
+			defer vault.Wipe(val)
+			return string(val), nil
+		}
+		return "", nil
+	}
+	switch column {
+	case "Group":
+		return d.vault.Group(rid), nil
+	case "Title":
+		return reveal("Title")
+	case "Username":
+		return reveal("UserName")
+	case "Password":
+		return reveal("Password")
+	case "URL":
+		return reveal("URL")
+	case "Notes":
+		return reveal("Notes")
+	case "TOTP":
+		// KeePassXC keeps otpauth:// URI in otp field
+		return reveal("otp", "TOTP Seed")
+	case "Icon":
+		return strconv.Itoa(int(entry.IconID)), nil
+	case "Last Modified":
+		return timeValue(recordTime(entry.Times.LastModificationTime)), nil
+	case "Created":
+		return timeValue(recordTime(entry.Times.CreationTime)), nil
+	}
+	return "", nil
+}
+
+// helper function to write given records of the database as CSV
+func (d *kdb) writeCSV(w io.Writer, rids []int, columns []string) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	for _, rid := range rids {
+		entry, _ := d.vault.Entry(rid)
+		row := make([]string, len(columns))
+		for i, col := range columns {
+			val, err := d.csvValue(rid, entry, col)
+			if err != nil {
+				return err
+			}
+			row[i] = val
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// cmdExport implements export command, it writes records of active
+// database including their plain text passwords
+func cmdExport(s *session, args []string) error {
+	opts, err := parseExportOptions(args)
+	if err != nil {
+		return err
+	}
+	d := s.active
+	rids, err := d.exportRecords(opts)
+	if err != nil {
+		return err
+	}
+	if len(rids) == 0 {
+		return fmt.Errorf("%w: no records to export", errNotFound)
+	}
+	target := opts.Output
+	if target == "" {
+		target = "stdout"
+	}
+	if !opts.Yes {
+		msg := fmt.Sprintf("Export %d records with plain text secrets to %s?", len(rids), target)
+		if !confirm(msg) {
+			return fmt.Errorf("%w, export is not confirmed, use --yes option to confirm it", errUsage)
+		}
+	}
+	if opts.Output == "" {
+		return d.writeCSV(os.Stdout, rids, opts.Columns)
+	}
+	file, err := os.OpenFile(opts.Output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// permissions of existing file are not changed by open
+	if err := file.Chmod(0600); err != nil {
+		return err
+	}
+	if err := d.writeCSV(file, rids, opts.Columns); err != nil {
+		return err
+	}
+	log.Printf("exported %d records to %s", len(rids), opts.Output)
+	return file.Close()
+}
@@ -0,0 +1,105 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	gokeepasslib "github.com/tobischo/gokeepasslib/v3"
+	"github.com/vkuznet/kpass/vault"
+)
+
+// helper function to create database with given records, records are added
+// to the group given by their group field
+func testDB(t *testing.T, recs []importRecord) *kdb {
+	t.Helper()
+	db := gokeepasslib.NewDatabase()
+	db.Credentials = gokeepasslib.NewPasswordCredentials("test")
+	root := gokeepasslib.NewGroup()
+	root.Name = "Root"
+	db.Content.Root.Groups = []gokeepasslib.Group{root}
+	path := filepath.Join(t.TempDir(), "test.kdbx")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := gokeepasslib.NewEncoder(file).Encode(db); err != nil {
+		t.Fatal(err)
+	}
+	v, err := vault.Open(path, "", []byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs {
+		if err := v.AddRecords(rec.Group, []vault.Record{rec.Rec}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &kdb{name: "test", vault: v}
+}
+
+// records used to test CSV export and import
+var csvRecords = []importRecord{
+	{Group: "", Rec: vault.Record{"Title": "GMail", "UserName": "alice@gmail.com",
+		"Password": "p,a\"ss", "URL": "https://mail.google.com"}},
+	{Group: "Dev", Rec: vault.Record{"Title": "GitHub", "UserName": "alice",
+		"Password": "secret", "Notes": "line one\nline two",
+		"otp": "otpauth://totp/GitHub:alice?secret=JBSWY3DPEHPK3PXP"}},
+	{Group: "Dev/Cloud", Rec: vault.Record{"Title": "AWS", "Password": "ключ"}},
+}
+
+func TestExportCSV(t *testing.T) {
+	d := testDB(t, csvRecords)
+	var buf bytes.Buffer
+	columns := []string{"Group", "Title", "Username", "Password", "Notes", "TOTP"}
+	if err := d.writeCSV(&buf, d.vault.IDs(), columns); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		columns,
+		{"Root", "GMail", "alice@gmail.com", "p,a\"ss", "", ""},
+		{"Root/Dev", "GitHub", "alice", "secret", "line one\nline two",
+			"otpauth://totp/GitHub:alice?secret=JBSWY3DPEHPK3PXP"},
+		{"Root/Dev/Cloud", "AWS", "", "ключ", "", ""},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("exported rows: got %q, want %q", rows, want)
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	d := testDB(t, csvRecords)
+	var buf bytes.Buffer
+	if err := d.writeCSV(&buf, d.vault.IDs(), csvColumns); err != nil {
+		t.Fatal(err)
+	}
+	records, preset, err := readCSV(&buf, importOptions{Mapping: map[string]string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preset == nil || preset.Name != "keepassxc" {
+		t.Fatalf("preset of exported file: got %+v, want keepassxc", preset)
+	}
+	if len(records) != len(csvRecords) {
+		t.Fatalf("imported records: got %d, want %d", len(records), len(csvRecords))
+	}
+	for i, rec := range records {
+		if rec.Group != csvRecords[i].Group || !reflect.DeepEqual(rec.Rec, csvRecords[i].Rec) {
+			t.Errorf("record %d: got %q %v, want %q %v", i, rec.Group, rec.Rec,
+				csvRecords[i].Group, csvRecords[i].Rec)
+		}
+	}
+}
@@ -4,14 +4,24 @@ go 1.19
 
 require (
 	github.com/atotto/clipboard v0.1.4
+	github.com/gdamore/tcell/v2 v2.5.4
+	github.com/peterh/liner v1.2.2
+	github.com/rivo/tview v0.0.0-20230208211350-7dfff1ce7854
 	github.com/tobischo/gokeepasslib/v3 v3.5.0
 	github.com/vkuznet/cryptoutils v0.0.0-20230126130457-394cb386ff0d
 	golang.org/x/crypto v0.5.0
+	golang.org/x/net v0.5.0
+	golang.org/x/sys v0.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/aead/argon2 v0.0.0-20180111183520-a87724528b07 // indirect
 	github.com/aead/chacha20 v0.0.0-20180709150244-8b13a72661da // indirect
-	golang.org/x/sys v0.4.0 // indirect
+	github.com/gdamore/encoding v1.0.0 // indirect
+	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
+	github.com/mattn/go-runewidth v0.0.14 // indirect
+	github.com/rivo/uniseg v0.4.2 // indirect
 	golang.org/x/term v0.4.0 // indirect
+	golang.org/x/text v0.6.0 // indirect
 )
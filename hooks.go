@@ -0,0 +1,128 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// hook events
+const (
+	hookPreSave    = "pre-save"    // before database file is written, failure aborts the write
+	hookPostSave   = "post-save"   // after database file is written
+	hookPostUnlock = "post-unlock" // after database is opened
+	hookOnLock     = "on-lock"     // when database is closed or kpass exits
+	hookOnCopy     = "on-copy"     // after record attribute is copied to clipboard
+)
+
+// hookEvents lists supported hook events
+var hookEvents = []string{hookPreSave, hookPostSave, hookPostUnlock, hookOnLock, hookOnCopy}
+
+// errHook is returned when pre-save hook fails
+var errHook = errors.New("hook failed")
+
+// Commands represents list of hook commands, in configuration file it can
+// be given either as single command or as list of commands
+type Commands []string
+
+// UnmarshalYAML implements yaml.Unmarshaler interface
+func (c *Commands) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*c = Commands{value.Value}
+		return nil
+	}
+	var cmds []string
+	if err := value.Decode(&cmds); err != nil {
+		return err
+	}
+	*c = cmds
+	return nil
+}
+
+// hooks holds configured hook commands of every event
+var hooks map[string]Commands
+
+// hookOutput defines where output of hook commands is written to
+var hookOutput io.Writer = os.Stderr
+
+// helper function to set hooks from given configurations, hooks of later
+// configurations are executed after earlier ones
+func setHooks(configs ...map[string]Commands) error {
+	hooks = make(map[string]Commands)
+	for _, cfg := range configs {
+		for event, cmds := range cfg {
+			if !validEvent(event) {
+				return fmt.Errorf("unsupported hook event '%s', supported events: %s",
+					event, strings.Join(hookEvents, ","))
+			}
+			hooks[event] = append(hooks[event], cmds...)
+		}
+	}
+	return nil
+}
+
+// helper function to check hook event name
+func validEvent(event string) bool {
+	for _, e := range hookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to run hook commands of given event, event details are
+// passed via KPASS_* environment variables and they never contain secrets.
+// Hook output is redirected to stderr to keep stdout clean.
+func runHooks(event string, details map[string]string) error {
+	cmds := hooks[event]
+	if len(cmds) == 0 {
+		return nil
+	}
+	env := append(os.Environ(), "KPASS_EVENT="+event)
+	var keys []string
+	for key := range details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, fmt.Sprintf("KPASS_%s=%s", key, details[key]))
+	}
+	for _, cmd := range cmds {
+		hook := shellCommand(cmd)
+		hook.Env = env
+		hook.Stdout = hookOutput
+		hook.Stderr = hookOutput
+		if err := hook.Run(); err != nil {
+			return fmt.Errorf("%w, %s hook '%s', %v", errHook, event, cmd, err)
+		}
+	}
+	return nil
+}
+
+// helper function to run hooks whose failure is not fatal
+func notifyHooks(event string, details map[string]string) {
+	if err := runHooks(event, details); err != nil {
+		log.Printf("WARNING: %v", err)
+	}
+}
+
+// helper function to return hook details of given database
+func (d *kdb) hookDetails() map[string]string {
+	return map[string]string{
+		"DB":       d.vault.Path(),
+		"DB_ALIAS": d.name,
+		"RECORDS":  fmt.Sprintf("%d", d.vault.Len()),
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/vkuznet/kpass/vault"
+)
+
+// importGroup defines pseudo field which holds group of imported record
+const importGroup = "Group"
+
+// importPreset represents mapping of CSV columns of known exporter to record
+// fields, columns which are not mapped are skipped
+type importPreset struct {
+	Name      string            // preset name
+	Columns   map[string]string // lower-cased column names and their fields
+	Signature []string          // columns used to detect the preset
+	RootGroup bool              // group paths include name of top level group
+}
+
+// importPresets lists presets of common exporters in the order they are
+// detected, i.e. more specific ones first
+var importPresets = []importPreset{
+	{Name: "keepassxc", RootGroup: true,
+		Columns: map[string]string{"group": importGroup, "title": "Title", "username": "UserName",
+			"password": "Password", "url": "URL", "notes": "Notes", "totp": "otp"},
+		Signature: []string{"group", "title", "username", "password", "url", "notes"}},
+	{Name: "lastpass",
+		Columns: map[string]string{"url": "URL", "username": "UserName", "password": "Password",
+			"totp": "otp", "extra": "Notes", "name": "Title", "grouping": importGroup},
+		Signature: []string{"url", "username", "password", "extra", "name", "grouping"}},
+	{Name: "bitwarden",
+		Columns: map[string]string{"folder": importGroup, "name": "Title", "notes": "Notes",
+			"login_uri": "URL", "login_username": "UserName", "login_password": "Password",
+			"login_totp": "otp"},
+		Signature: []string{"folder", "name", "login_uri", "login_username", "login_password"}},
+	{Name: "firefox",
+		Columns:   map[string]string{"url": "URL", "username": "UserName", "password": "Password"},
+		Signature: []string{"url", "username", "password", "httprealm", "formactionorigin"}},
+	{Name: "chrome",
+		Columns: map[string]string{"name": "Title", "url": "URL", "username": "UserName",
+			"password": "Password", "note": "Notes"},
+		Signature: []string{"name", "url", "username", "password"}},
+}
+
+// genericColumns maps common column names to record fields, columns of
+// generic CSV files which are not listed here are imported as custom fields
+var genericColumns = map[string]string{
+	"title": "Title", "name": "Title", "password": "Password", "tags": "Tags",
+	"username": "UserName", "user": "UserName", "login": "UserName",
+	"url": "URL", "uri": "URL", "website": "URL", "totp": "otp", "otp": "otp",
+	"notes": "Notes", "note": "Notes", "extra": "Notes",
+	"group": importGroup, "folder": importGroup, "grouping": importGroup,
+}
+
+// standardFields maps lower-cased names of standard fields to their keys
+var standardFields = map[string]string{
+	"group": importGroup, "title": "Title", "username": "UserName", "password": "Password",
+	"url": "URL", "notes": "Notes", "tags": "Tags", "otp": "otp",
+}
+
+// importOptions represents options of import command
+type importOptions struct {
+	Preset  string            // preset name, detected from CSV header if empty
+	Mapping map[string]string // explicit mapping of lower-cased columns to fields
+	Group   string            // target group of imported records
+	DryRun  bool              // only show what would be imported
+}
+
+// helper function to parse import options and return remaining arguments
+func parseImportOptions(args []string) (importOptions, []string, error) {
+	opts := importOptions{Mapping: make(map[string]string)}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, val, hasVal := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") {
+			rest = append(rest, arg)
+			continue
+		}
+		if name == "dry-run" {
+			opts.DryRun = true
+			continue
+		}
+		if !hasVal {
+			if i+1 == len(args) {
+				return opts, rest, fmt.Errorf("%w, missing value of %s option", errUsage, arg)
+			}
+			i++
+			val = args[i]
+		}
+		switch name {
+		case "preset":
+			if findPreset(val) == nil {
+				var names []string
+				for _, p := range importPresets {
+					names = append(names, p.Name)
+				}
+				return opts, rest, fmt.Errorf("%w, unknown preset '%s', supported presets: %s",
+					errUsage, val, strings.Join(names, ","))
+			}
+			opts.Preset = val
+		case "map":
+			col, field, ok := strings.Cut(val, "=")
+			if !ok || strings.TrimSpace(col) == "" || strings.TrimSpace(field) == "" {
+				return opts, rest, fmt.Errorf("%w, mapping should be given as column=field", errUsage)
+			}
+			opts.Mapping[strings.ToLower(strings.TrimSpace(col))] = strings.TrimSpace(field)
+		case "group":
+			opts.Group = val
+		default:
+			return opts, rest, fmt.Errorf("%w, unknown option %s", errUsage, arg)
+		}
+	}
+	return opts, rest, nil
+}
+
+// helper function to find import preset by its name
+func findPreset(name string) *importPreset {
+	for i := range importPresets {
+		if importPresets[i].Name == name {
+			return &importPresets[i]
+		}
+	}
+	return nil
+}
+
+// helper function to detect import preset of given lower-cased CSV header
+func detectPreset(header []string) *importPreset {
+	for i := range importPresets {
+		found := true
+		for _, col := range importPresets[i].Signature {
+			if !inList(col, header) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return &importPresets[i]
+		}
+	}
+	return nil
+}
+
+// helper function to return record fields of given CSV header, empty field
+// means that column is skipped
+func (opts importOptions) fields(header []string) ([]string, *importPreset) {
+	preset := findPreset(opts.Preset)
+	if preset == nil {
+		preset = detectPreset(header)
+	}
+	fields := make([]string, len(header))
+	for i, col := range header {
+		switch {
+		case preset != nil:
+			fields[i] = preset.Columns[col]
+		case genericColumns[col] != "":
+			fields[i] = genericColumns[col]
+		default:
+			fields[i] = col
+		}
+		if field, ok := opts.Mapping[col]; ok {
+			fields[i] = field
+		}
+		if fields[i] == "-" {
+			fields[i] = ""
+		}
+		// names of standard fields are case-insensitive
+		if field, ok := standardFields[strings.ToLower(fields[i])]; ok {
+			fields[i] = field
+		}
+	}
+	return fields, preset
+}
+
+// importRecord represents record read from CSV file
+type importRecord struct {
+	Row   int          // row number in CSV file
+	Group string       // group path of the record
+	Rec   vault.Record // record fields
+}
+
+// helper function to read records of given CSV file
+func readCSV(r io.Reader, opts importOptions) ([]importRecord, *importPreset, error) {
+	// skip byte order mark written by some exporters
+	buf := bufio.NewReader(r)
+	if bom, err := buf.Peek(3); err == nil && string(bom) == "\ufeff" {
+		buf.Discard(3)
+	}
+	reader := csv.NewReader(buf)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read CSV header, %v", err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+	fields, preset := opts.fields(header)
+	var records []importRecord
+	for row := 2; ; row++ {
+		values, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, preset, fmt.Errorf("unable to read CSV row %d, %v", row, err)
+		}
+		rec := importRecord{Row: row, Rec: make(vault.Record)}
+		for i, val := range values {
+			if i >= len(fields) || fields[i] == "" || strings.TrimSpace(val) == "" {
+				continue
+			}
+			if fields[i] == importGroup {
+				// LastPass separates nested groups by backslash
+				rec.Group = strings.Trim(strings.ReplaceAll(val, `\`, "/"), "/")
+				if preset != nil && preset.RootGroup {
+					_, rec.Group, _ = strings.Cut(rec.Group, "/")
+				}
+				continue
+			}
+			rec.Rec[fields[i]] = val
+		}
+		if len(rec.Rec) == 0 {
+			continue
+		}
+		if rec.Rec["Title"] == "" && rec.Rec["URL"] != "" {
+			// e.g. Firefox does not export titles
+			if host := urlHostname(rec.Rec["URL"]); host != "" {
+				rec.Rec["Title"] = strings.TrimPrefix(host, "www.")
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, preset, nil
+}
+
+// helper function to return host of given URL, URLs without scheme are
+// treated as https ones
+func urlHostname(val string) string {
+	if !strings.Contains(val, "://") {
+		val = "https://" + val
+	}
+	u, err := url.Parse(strings.TrimSpace(val))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// helper function to return duplicate key of record, i.e. its URL and
+// username, records without both of them are never duplicates
+func duplicateKey(link, user string) string {
+	link = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(link)), "/")
+	user = strings.ToLower(strings.TrimSpace(user))
+	if link == "" && user == "" {
+		return ""
+	}
+	return link + "\x00" + user
+}
+
+// cmdImport implements import command, it imports records of CSV file into
+// active database
+func cmdImport(s *session, args []string) error {
+	opts, args, err := parseImportOptions(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 || args[0] != "csv" {
+		return fmt.Errorf("%w, usage: import csv <file>", errUsage)
+	}
+	file, err := os.Open(expandPath(args[1]))
+	if err != nil {
+		return vault.FileError(err)
+	}
+	defer file.Close()
+	records, preset, err := readCSV(file, opts)
+	if err != nil {
+		return err
+	}
+	if preset != nil {
+		log.Printf("import %s using %s preset", args[1], preset.Name)
+	}
+	d := s.active
+
+	// detect duplicates of existing records and records of the file
+	known := make(map[string]string)
+	for _, rid := range d.vault.IDs() {
+		entry, _ := d.vault.Entry(rid)
+		if key := duplicateKey(entry.GetContent("URL"), entry.GetContent("UserName")); key != "" {
+			known[key] = "record " + d.label(rid)
+		}
+	}
+	groups := make(map[string][]vault.Record)
+	var paths []string
+	added, skipped := 0, 0
+	for _, rec := range records {
+		path := strings.Trim(strings.Join([]string{strings.Trim(opts.Group, "/"), rec.Group}, "/"), "/")
+		name := strings.Trim(path+"/"+rec.Rec["Title"], "/")
+		key := duplicateKey(rec.Rec["URL"], rec.Rec["UserName"])
+		if dup, ok := known[key]; ok && key != "" {
+			skipped++
+			if opts.DryRun {
+				fmt.Printf("skip row %-4d %s, duplicate of %s\n", rec.Row, name, dup)
+			}
+			continue
+		}
+		if key != "" {
+			known[key] = fmt.Sprintf("row %d", rec.Row)
+		}
+		added++
+		if opts.DryRun {
+			var keys []string
+			for k := range rec.Rec {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			fmt.Printf("add  row %-4d %s (%s)\n", rec.Row, name, strings.Join(keys, ","))
+			continue
+		}
+		if _, ok := groups[path]; !ok {
+			paths = append(paths, path)
+		}
+		groups[path] = append(groups[path], rec.Rec)
+	}
+	if opts.DryRun {
+		log.Printf("dry run: %d records to import, %d duplicates to skip", added, skipped)
+		return nil
+	}
+	if added == 0 {
+		return fmt.Errorf("%w: no records to import, %d duplicates skipped", errNotFound, skipped)
+	}
+	for _, path := range paths {
+		if err := d.vault.AddRecords(path, groups[path]); err != nil {
+			return err
+		}
+	}
+	log.Printf("imported %d records, %d duplicates skipped", added, skipped)
+	return d.update()
+}
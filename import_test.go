@@ -0,0 +1,155 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vkuznet/kpass/vault"
+)
+
+func TestDetectPreset(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"Group,Title,Username,Password,URL,Notes,TOTP,Icon,Last Modified,Created", "keepassxc"},
+		{"url,username,password,totp,extra,name,grouping,fav", "lastpass"},
+		{"folder,favorite,type,name,notes,fields,reprompt,login_uri,login_username,login_password,login_totp", "bitwarden"},
+		{"url,username,password,httpRealm,formActionOrigin,guid,timeCreated,timeLastUsed,timePasswordChanged", "firefox"},
+		{"name,url,username,password,note", "chrome"},
+		{"title,user,password,website", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		header := strings.Split(strings.ToLower(tt.header), ",")
+		got := ""
+		if preset := detectPreset(header); preset != nil {
+			got = preset.Name
+		}
+		if got != tt.want {
+			t.Errorf("header %q: got preset %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		opts   importOptions
+		preset string
+		want   []importRecord
+	}{
+		{name: "keepassxc with byte order mark",
+			data: "\ufeff\"Group\",\"Title\",\"Username\",\"Password\",\"URL\",\"Notes\",\"TOTP\"\n" +
+				"\"Root/Dev\",\"GitHub\",\"alice\",\"secret\",\"\",\"a\nb\",\"\"\n" +
+				"\"Root\",\"GMail\",\"\",\"pw\",\"https://mail.google.com\",\"\",\"\"\n",
+			preset: "keepassxc",
+			want: []importRecord{
+				{Row: 2, Group: "Dev", Rec: vault.Record{"Title": "GitHub", "UserName": "alice",
+					"Password": "secret", "Notes": "a\nb"}},
+				{Row: 3, Group: "", Rec: vault.Record{"Title": "GMail", "Password": "pw",
+					"URL": "https://mail.google.com"}},
+			}},
+		{name: "lastpass nested groups",
+			data: "url,username,password,totp,extra,name,grouping,fav\n" +
+				`https://github.com,alice,secret,,ssh key,GitHub,Work\Dev,0` + "\n" +
+				"http://sn,,,,secure note,Note,,0\n",
+			preset: "lastpass",
+			want: []importRecord{
+				{Row: 2, Group: "Work/Dev", Rec: vault.Record{"Title": "GitHub", "URL": "https://github.com",
+					"UserName": "alice", "Password": "secret", "Notes": "ssh key"}},
+				{Row: 3, Rec: vault.Record{"Title": "Note", "URL": "http://sn", "Notes": "secure note"}},
+			}},
+		{name: "bitwarden folders",
+			data: "folder,favorite,type,name,notes,fields,reprompt,login_uri,login_username,login_password,login_totp\n" +
+				"Personal,,login,Bank,pin,,0,https://bank.com,alice,secret,\n",
+			preset: "bitwarden",
+			want: []importRecord{
+				{Row: 2, Group: "Personal", Rec: vault.Record{"Title": "Bank", "Notes": "pin",
+					"URL": "https://bank.com", "UserName": "alice", "Password": "secret"}},
+			}},
+		{name: "firefox titles of URLs",
+			data: "url,username,password,httpRealm,formActionOrigin,guid\n" +
+				"https://www.example.com,alice,secret,,https://www.example.com,{1}\n",
+			preset: "firefox",
+			want: []importRecord{
+				{Row: 2, Rec: vault.Record{"Title": "example.com", "URL": "https://www.example.com",
+					"UserName": "alice", "Password": "secret"}},
+			}},
+		{name: "generic columns and custom fields",
+			data: "Name,Login,Password,Website,Env,Tags\n" +
+				"db,admin,secret,db.example.com,prod,\n" +
+				",,,,,\n",
+			want: []importRecord{
+				{Row: 2, Rec: vault.Record{"Title": "db", "UserName": "admin", "Password": "secret",
+					"URL": "db.example.com", "env": "prod"}},
+			}},
+		{name: "explicit mapping",
+			data: "Name,Login,Password,Website,Env\n" +
+				"db,admin,secret,db.example.com,prod\n",
+			opts: importOptions{Mapping: map[string]string{"website": "-", "env": "Environment",
+				"login": "username"}},
+			want: []importRecord{
+				{Row: 2, Rec: vault.Record{"Title": "db", "UserName": "admin", "Password": "secret",
+					"Environment": "prod"}},
+			}},
+		{name: "explicit preset",
+			data: "name,url,username,password\n" +
+				"GitHub,https://github.com,alice,secret\n",
+			opts:   importOptions{Preset: "lastpass"},
+			preset: "lastpass",
+			want: []importRecord{
+				{Row: 2, Rec: vault.Record{"Title": "GitHub", "URL": "https://github.com",
+					"UserName": "alice", "Password": "secret"}},
+			}},
+	}
+	for _, tt := range tests {
+		if tt.opts.Mapping == nil {
+			tt.opts.Mapping = map[string]string{}
+		}
+		records, preset, err := readCSV(strings.NewReader(tt.data), tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		name := ""
+		if preset != nil {
+			name = preset.Name
+		}
+		if name != tt.preset {
+			t.Errorf("%s: got preset %q, want %q", tt.name, name, tt.preset)
+		}
+		if !reflect.DeepEqual(records, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, records, tt.want)
+		}
+	}
+	if _, _, err := readCSV(strings.NewReader(""), importOptions{}); err == nil {
+		t.Error("CSV file without header is accepted")
+	}
+}
+
+func TestParseImportOptions(t *testing.T) {
+	opts, rest, err := parseImportOptions([]string{"--preset", "bitwarden", "--map=Env=Environment",
+		"--group", "Root/Imported", "--dry-run", "export.csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := importOptions{Preset: "bitwarden", Mapping: map[string]string{"env": "Environment"},
+		Group: "Root/Imported", DryRun: true}
+	if !reflect.DeepEqual(opts, want) || !reflect.DeepEqual(rest, []string{"export.csv"}) {
+		t.Errorf("import options: got %+v %v, want %+v [export.csv]", opts, rest, want)
+	}
+	for _, args := range [][]string{{"--preset", "other"}, {"--map", "env"}, {"--group"}, {"--force"}} {
+		if _, _, err := parseImportOptions(args); !errors.Is(err, errUsage) {
+			t.Errorf("options %v: got %v, want %v", args, err, errUsage)
+		}
+	}
+}
@@ -10,376 +10,374 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"regexp"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	gokeepasslib "github.com/tobischo/gokeepasslib/v3"
-	wrappers "github.com/tobischo/gokeepasslib/v3/wrappers"
+	"github.com/vkuznet/kpass/vault"
 )
 
-// DBRecords defines map of DB records
-type DBRecords map[int]gokeepasslib.Entry
+// kdb represents opened KeePass database along with its session state
+type kdb struct {
+	name       string       // database alias
+	vault      *vault.Vault // opened database
+	prefix     string       // prefix of record IDs if several databases are opened
+	rec        vault.Record // record collected via add command
+	collectKey string       // record key we collect value for
+	secrets    [][]byte     // buffers of secret values of collected record
+	deferred   bool         // postpone writing of database file until commit
+}
 
-// Record represent record map
-type Record map[string]string
+// helper function to derive database alias from its file name
+func dbAlias(kpath string) string {
+	name := filepath.Base(kpath)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
 
-// keep track if user requested password field
-var inputPwd bool
+// helper function to open KeePass database, it reads database password
+// via provided password reader and returns opened database
+func openDB(kpath, kfile string, readPwd func(string) ([]byte, error)) (*kdb, error) {
+	if _, err := os.Stat(kpath); err != nil {
+		return nil, vault.FileError(err)
+	}
+	pwd, err := readPwd("db password: ")
+	if err != nil {
+		return nil, err
+	}
+	// master password is kept in locked memory by the vault and wiped on exit
+	v, err := vault.Open(kpath, kfile, pwd)
+	if err != nil {
+		return nil, err
+	}
+	d := &kdb{name: dbAlias(kpath), vault: v}
+	onWipeSecrets(d.lock)
+	notifyHooks(hookPostUnlock, d.hookDetails())
+	return d, nil
+}
 
-// global db records
-var dbRecords DBRecords
+// helper function to lock the database, it is safe to call it several times
+func (d *kdb) lock() {
+	if d.vault.Locked() {
+		return
+	}
+	notifyHooks(hookOnLock, d.hookDetails())
+	d.vault.Lock()
+}
 
-// helper function to mange KeePass database
-func manageKeePass(kpath, kfile, pwd, cipher string, interval int) {
+// helper function to unlock locked database with given password, the
+// database is read again from its file
+func (d *kdb) unlock(pwd []byte) error {
+	if err := d.vault.Unlock(pwd); err != nil {
+		return err
+	}
+	notifyHooks(hookPostUnlock, d.hookDetails())
+	return nil
+}
 
-	file, err := os.Open(kpath)
-	if err != nil {
-		log.Fatal(err)
+// helper function to discard record collected via add command, buffers of
+// its secret values are wiped
+func (d *kdb) discardRecord() {
+	for _, buf := range d.secrets {
+		vault.Wipe(buf)
 	}
+	d.secrets = nil
+	d.rec = nil
+	d.collectKey = ""
+}
 
-	db := gokeepasslib.NewDatabase()
-	pwd = readPassword("db password: ")
-	if kfile != "" {
-		db.Credentials, err = gokeepasslib.NewPasswordAndKeyCredentials(pwd, kfile)
-		if err != nil {
-			log.Fatalf("ERROR: unable to get credentials, %v", err)
-		}
-	} else {
-		db.Credentials = gokeepasslib.NewPasswordCredentials(pwd)
+// helper function to mange KeePass database
+func manageKeePass(kpath, kfile, cipher string, interval int) {
+	d, err := openDB(kpath, kfile, pwdSource.read)
+	if err != nil {
+		fatal(err)
 	}
-	_ = gokeepasslib.NewDecoder(file).Decode(db)
-	if db.Content.Root == nil {
-		msg := "ERROR: wrong password"
-		log.Fatal(msg)
+	s := &session{
+		kfile:       kfile,
+		cipher:      cipher,
+		timeout:     time.Duration(interval) * time.Second,
+		interactive: true,
 	}
-	db.UnlockProtectedEntries()
+	s.add(d)
 
 	time0 := time.Now()
-	timeout := time.Duration(interval) * time.Second
-	err = readDB(db)
-	if err != nil {
-		log.Fatal(err)
-	}
 
 	// proceed with db records
 	cmdUsage(kpath)
-	var names []string
-	for _, g := range db.Content.Root.Groups {
-		names = append(names, g.Name)
-	}
-	fmt.Printf("Welcome to %s (%d records)", strings.Join(names, ","), len(dbRecords))
+	fmt.Printf("Welcome to %s (%d records)", d.vault.Name(), d.vault.Len())
 
-	inputMsg := "\ndb # "
-	inputMsgOrig := inputMsg
-	inputPwd = false
-	fmt.Printf(inputMsg)
+	fmt.Println()
 
-	// we'll read out std input via goroutine
-	ch := make(chan string)
-	go readInputChannel(ch)
-
-	// read stdin and search for DB record
-	patCopy, err := regexp.Compile(`cp [0-9]+`)
-	if err != nil {
-		log.Fatal(err)
-	}
-	patRemove, err := regexp.Compile(`rm [0-9]+`)
-	if err != nil {
-		log.Fatal(err)
-	}
-	patAdd, err := regexp.Compile(`add [a-zA-Z]+`)
-	if err != nil {
-		log.Fatal(err)
-	}
-	patEncrypt, err := regexp.Compile(`encrypt [0-9]+`)
-	if err != nil {
-		log.Fatal(err)
-	}
-	patDecrypt, err := regexp.Compile(`decrypt [0-9]+`)
-	if err != nil {
-		log.Fatal(err)
-	}
-	patTimeout, err := regexp.Compile(`timeout [0-9]+`)
-	if err != nil {
-		log.Fatal(err)
-	}
+	// we'll read out std input via goroutine, it reads next line upon request
+	line := newLineEditor(s)
+	req := make(chan inputRequest, 1)
+	ch := make(chan inputLine)
+	go readInputChannel(line, req, ch)
+	req <- inputRequest{prompt: s.prompt(), history: true}
 
 	// main loop
-	var rec Record
-	rec = nil
-	collectKey := ""
 	for {
 		select {
-		case input := <-ch:
-			input = strings.Replace(input, "\n", "", -1)
-			if input == "save" {
-				saveRecord(kpath, kfile, pwd, db, rec)
-				rec = nil
-				collectKey = ""
-				inputMsg = inputMsgOrig
-			} else if input == "timeout" {
-				fmt.Println("Current DB timeout is", timeout, " seconds")
-			} else if input == "exit" || input == "quit" {
-				os.Exit(0)
-			} else if strings.HasPrefix(input, "WARNING") {
-				collectKey = ""
+		case in := <-ch:
+			input := strings.TrimRight(in.text, "\r\n")
+			d := s.active
+			if strings.HasPrefix(input, "WARNING") {
+				d.collectKey = ""
 				fmt.Println(input)
-				inputMsg = inputMsgOrig
-			} else if collectKey != "" {
-				rec[collectKey] = input
-				collectKey = ""
-				inputMsg = inputMsgOrig
-			} else if matched := patEncrypt.MatchString(input); matched {
-				fname := strings.Replace(input, "encrypt", "", -1)
-				fname = strings.Trim(fname, " ")
-				encryptFile(fname, kfile, cipher)
-			} else if matched := patDecrypt.MatchString(input); matched {
-				fname := strings.Replace(input, "encrypt", "", -1)
-				fname = strings.Trim(fname, " ")
-				decryptFile(fname, kfile, cipher)
-			} else if matched := patCopy.MatchString(input); matched {
-				clipboardCopy(input)
-				inputMsg = inputMsgOrig
-			} else if matched := patRemove.MatchString(input); matched {
-				if rid, err := strconv.Atoi(input); err == nil {
-					removeRecord(kpath, kfile, pwd, db, rid)
+			} else if d.collectKey != "" && in.secret != nil {
+				d.rec[d.collectKey] = vault.SecretString(in.secret)
+				d.secrets = append(d.secrets, in.secret)
+				d.collectKey = ""
+			} else if d.collectKey != "" {
+				d.rec[d.collectKey] = input
+				d.collectKey = ""
+			} else if err := s.execute(input); err != nil {
+				if errors.Is(err, errNotFound) {
+					fmt.Println("No records found")
 				} else {
-					log.Printf("ERROR: unable to parse record id, error: %v", err)
-				}
-				inputMsg = inputMsgOrig
-			} else if matched := patAdd.MatchString(input); matched {
-				if rec == nil {
-					rec = make(map[string]string)
-				}
-				collectKey = strings.Replace(input, "add ", "", -1)
-				if strings.ToLower(collectKey) == "password" {
-					inputPwd = true
-					fmt.Println("set encrypted input for password field")
+					log.Printf("ERROR: %v", err)
 				}
-				inputMsg = fmt.Sprintf("%s value: ", collectKey)
-			} else if matched := patTimeout.MatchString(input); matched {
-				vvv := strings.Trim(strings.Replace(input, "timeout ", "", -1), " ")
-				if val, err := strconv.Atoi(vvv); err == nil {
-					timeout = time.Duration(val)
-					fmt.Printf("New DB timeout is set to %d seconds", timeout)
+			}
+			// values of record keys are never added to history
+			if key := s.active.collectKey; key != "" {
+				req <- inputRequest{
+					prompt:   fmt.Sprintf("%s value: ", key),
+					password: strings.ToLower(key) == "password",
 				}
-				inputMsg = inputMsgOrig
 			} else {
-				search(input)
-				inputMsg = inputMsgOrig
+				req <- inputRequest{prompt: s.prompt(), history: true}
 			}
 			time0 = time.Now()
-			fmt.Printf(inputMsg)
 		default:
-			if time.Since(time0) > timeout {
+			if time.Since(time0) > s.timeout {
 				fmt.Printf("\nExit after %s of inactivity", time.Since(time0))
-				os.Exit(1)
+				exit(1)
 			}
 			time.Sleep(time.Duration(1) * time.Millisecond) // wait for new input
 		}
 	}
 }
 
-// helper function to remove record from the database
-func removeRecord(dbPath, kfile, pwd string, db *gokeepasslib.Database, rid int) {
-	// find our record for given input
-	recEntry := dbRecords[rid]
-
-	// iterate over existing db entries and add it to our group
-	// but skip our record entry corresponding to given record id
-	group := gokeepasslib.NewGroup()
-	// iterate over existing db entries and add it to our group
-	for _, top := range db.Content.Root.Groups {
-		group.Name = top.Name
-		for _, entry := range top.Entries {
-			if entry.UUID != recEntry.UUID {
-				group.Entries = append(group.Entries, entry)
-			}
-		}
-		for _, groups := range top.Groups {
-			for _, entry := range groups.Entries {
-				if entry.UUID != recEntry.UUID {
-					group.Entries = append(group.Entries, entry)
-				}
-			}
-		}
-	}
-
-	// write new database file
-	writeNewDB(dbPath, kfile, pwd, group)
+// helper function to find database record by its ID or path
+func (d *kdb) findRecord(key string) (int, gokeepasslib.Entry, error) {
+	return d.vault.Get(key)
 }
 
-// helper function to make entry db value
-func mkValue(key string, value string) gokeepasslib.ValueData {
-	return gokeepasslib.ValueData{Key: key, Value: gokeepasslib.V{Content: value}}
+// helper function to write database changes unless writes are deferred
+func (d *kdb) update() error {
+	if d.deferred {
+		return nil
+	}
+	return d.commit()
 }
 
-// helper function to make protected entry db value
-func mkProtectedValue(key string, value string) gokeepasslib.ValueData {
-	return gokeepasslib.ValueData{
-		Key:   key,
-		Value: gokeepasslib.V{Content: value, Protected: wrappers.NewBoolWrapper(true)},
+// helper function to write pending changes of the database
+func (d *kdb) commit() error {
+	if !d.vault.Dirty() {
+		return nil
 	}
+	details := d.hookDetails()
+	details["DB_NEW"] = d.vault.SavePath()
+	if err := runHooks(hookPreSave, details); err != nil {
+		return fmt.Errorf("database is not written, %w", err)
+	}
+	if err := d.vault.Save(); err != nil {
+		return err
+	}
+	log.Printf("Wrote kdbx file: %s", d.vault.SavePath())
+	notifyHooks(hookPostSave, details)
+	return nil
 }
 
-// helper function to save record to the database
-func saveRecord(dbPath, kfile, pwd string, db *gokeepasslib.Database, rec Record) {
-
-	// add Title to record if it is missing
-	if _, ok := rec["Title"]; !ok {
-		rec["Title"] = "Record"
+// helper function to remove record from the database
+func (d *kdb) removeRecord(key string) error {
+	if err := d.vault.Delete(key); err != nil {
+		return err
 	}
+	return d.update()
+}
 
-	// create new group and entry objects
-	group := gokeepasslib.NewGroup()
-	entry := gokeepasslib.NewEntry()
-
-	// iterate over existing db entries and add it to our group
-	for _, top := range db.Content.Root.Groups {
-		group.Name = top.Name
-		for _, entry := range top.Entries {
-			group.Entries = append(group.Entries, entry)
-		}
-		for _, groups := range top.Groups {
-			for _, entry := range groups.Entries {
-				group.Entries = append(group.Entries, entry)
-			}
+// helper function to parse key=value pairs into record
+func parseRecord(args []string) vault.Record {
+	rec := make(vault.Record)
+	for _, arg := range args {
+		arr := strings.SplitN(arg, "=", 2)
+		if len(arr) == 2 {
+			rec[arr[0]] = arr[1]
 		}
 	}
+	return rec
+}
 
-	// now we'll add our new record to group entries
-	for key, val := range rec {
-		attr := strings.ToLower(key)
-		if attr == "password" {
-			entry.Values = append(entry.Values, mkProtectedValue("Password", val))
-		} else {
-			key = strings.Title(key)
-			if attr == "username" {
-				key = "UserName"
-			}
-			entry.Values = append(entry.Values, mkValue(key, val))
-		}
+// helper function to save new record to the database
+func (d *kdb) saveRecord(rec vault.Record) error {
+	if err := d.vault.Put("", rec); err != nil {
+		return err
 	}
-
-	// add entry to our db records object
-	rid := len(dbRecords) + 1
-	dbRecords[rid] = entry
-
-	// update db group entries
-	group.Entries = append(group.Entries, entry)
-
-	// write new database file
-	writeNewDB(dbPath, kfile, pwd, group)
+	return d.update()
 }
 
-// helper function to write new database file with given group
-func writeNewDB(dbPath, kfile, pwd string, group gokeepasslib.Group) {
-
-	var err error
-
-	// write group entries to DB
-	// https://github.com/tobischo/gokeepasslib/blob/master/examples/writing/example-writing.go
-	creds := gokeepasslib.NewPasswordCredentials(pwd)
-	if kfile != "" {
-		creds, err = gokeepasslib.NewPasswordAndKeyCredentials(pwd, kfile)
-		if err != nil {
-			log.Fatal(err)
-		}
+// helper function to edit existing record of the database
+func (d *kdb) editRecord(key string, rec vault.Record) error {
+	if len(rec) == 0 {
+		return errors.New("no attributes to edit, use key=value pairs")
 	}
-	newdb := &gokeepasslib.Database{
-		Header:      gokeepasslib.NewHeader(),
-		Credentials: creds,
-		Content: &gokeepasslib.DBContent{
-			Meta: gokeepasslib.NewMetaData(),
-			Root: &gokeepasslib.RootData{
-				Groups: []gokeepasslib.Group{group},
-			},
-		},
+	if err := d.vault.Put(key, rec); err != nil {
+		return err
 	}
-	filename := fmt.Sprintf("%s-new", dbPath)
-	file, err := os.Create(filename)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	// Lock entries using stream cipher
-	newdb.LockProtectedEntries()
-
-	// and encode it into the file
-	keepassEncoder := gokeepasslib.NewEncoder(file)
-	if err := keepassEncoder.Encode(newdb); err != nil {
-		log.Fatal(err)
-	}
-	log.Printf("Wrote kdbx file: %s", filename)
-	//     db.UnlockProtectedEntries()
+	return d.update()
 }
 
-// helper function to get value of kdbx record
+// helper function to get value of kdbx record, protected values are masked
 func getValue(entry gokeepasslib.Entry, key string) string {
 	if ptr := entry.Get(key); ptr != nil {
 		if ptr.Key == key {
+			if vault.IsProtected(*ptr) {
+				return "********"
+			}
 			return fmt.Sprintf("%+v", ptr.Value.Content)
 		}
 	}
 	return ""
 }
 
-// helper function to read db records
-func readDB(db *gokeepasslib.Database) error {
-	if dbRecords == nil {
-		dbRecords = make(DBRecords)
-	}
+// helper function to return label of given record ID
+func (d *kdb) label(rid int) string {
+	return fmt.Sprintf("%s%d", d.prefix, rid)
+}
 
-	rid := 0
-	for _, top := range db.Content.Root.Groups {
-		if top.Name == "NewDatabase" {
-			msg := "ERROR: wrong password or empty database"
-			return errors.New(msg)
+// helper function to print record
+func (d *kdb) printRecord(rid int, entry gokeepasslib.Entry) {
+	fmt.Printf("---\n")
+	fmt.Printf("Record   %s\n", d.label(rid))
+	fmt.Printf("Title    %s\n", getValue(entry, "Title"))
+	fmt.Printf("Login    %s\n", getValue(entry, "Login"))
+	fmt.Printf("UserName %s\n", getValue(entry, "UserName"))
+	fmt.Printf("URL      %s\n", getValue(entry, "URL"))
+	fmt.Printf("Notes    %s\n", getValue(entry, "Notes"))
+	fmt.Printf("Tags     %s\n", entry.Tags)
+}
+
+// helper function to show all fields of db record, protected fields are
+// shown only if reveal option is set
+func (d *kdb) showRecord(key string, opts outputOptions) error {
+	rid, _, err := d.findRecord(key)
+	if err != nil {
+		return err
+	}
+	return d.printRecords([]int{rid}, opts, func(rid int, entry gokeepasslib.Entry) {
+		fmt.Printf("---\n")
+		fmt.Printf("Record   %s\n", d.label(rid))
+		for _, val := range entry.Values {
+			if opts.Reveal && vault.IsProtected(val) {
+				data, err := d.vault.Reveal(entry, val.Key)
+				if err != nil {
+					log.Printf("ERROR: unable to reveal %s, %v", val.Key, err)
+					continue
+				}
+				fmt.Printf("%-8s %s\n", val.Key, data)
+				vault.Wipe(data)
+				continue
+			}
+			fmt.Printf("%-8s %s\n", val.Key, getValue(entry, val.Key))
 		}
-		for _, entry := range top.Entries {
-			dbRecords[rid] = entry
-			rid += 1
+		fmt.Printf("Tags     %s\n", entry.Tags)
+	})
+}
+
+// helper function to print value of given record field, protected values
+// are revealed since this is used to access them from scripts. If output
+// format is given the record is printed with requested field only.
+func (d *kdb) getRecord(key, field string, opts outputOptions) error {
+	rid, entry, err := d.findRecord(key)
+	if err != nil {
+		return err
+	}
+	attr := vault.FieldKey(entry, field)
+	if entry.Get(attr) == nil {
+		return fmt.Errorf("%w: %s has no %s field", errNotFound, key, field)
+	}
+	val, err := d.vault.Reveal(entry, attr)
+	if err != nil {
+		return err
+	}
+	defer vault.Wipe(val)
+	if opts.Format != "" {
+		info, err := d.recordInfo(rid, entry, false)
+		if err != nil {
+			return err
 		}
-		for _, groups := range top.Groups {
-			for _, entry := range groups.Entries {
-				dbRecords[rid] = entry
-				rid += 1
-			}
+		info.Fields = map[string]string{attr: string(val)}
+		if err := writeRecords([]RecordInfo{info}, opts.Format); err != nil {
+			return err
 		}
+		d.touch(rid)
+		return nil
 	}
+	fmt.Println(string(val))
+	d.touch(rid)
 	return nil
 }
 
-// helper function to search for given input
-func search(input string) {
-	keys := []string{"UserName", "URL", "Notes", "Login", "Email"}
-	pat := regexp.MustCompile(input)
-	for rid, entry := range dbRecords {
-		if strings.Contains(entry.GetTitle(), input) ||
-			strings.Contains(entry.Tags, input) {
-			printRecord(rid, entry)
-		} else {
-			for _, k := range keys {
-				val := getValue(entry, k)
-				if pat.MatchString(val) {
-					printRecord(rid, entry)
-				}
+// helper function to record usage of given record, failures are only logged
+// since the record was already used. Usage is kept in memory and written
+// along with the next database change, reading records never writes the
+// database file.
+func (d *kdb) touch(rid int) {
+	if err := d.vault.Touch(strconv.Itoa(rid)); err != nil {
+		log.Printf("WARNING: unable to record usage of %s, %v", d.label(rid), err)
+	}
+}
+
+// savedGroup defines virtual group of saved searches, every saved search is
+// shown as its sub-group holding records matched by the search
+const savedGroup = "Saved Searches"
+
+// helper function to return IDs of db records within given group, records
+// of virtual groups are found by corresponding saved searches
+func (d *kdb) groupRecords(group string) []int {
+	var rids []int
+	if group != savedGroup && !strings.HasPrefix(group, savedGroup+"/") {
+		for _, rid := range d.vault.IDs() {
+			path := d.vault.Group(rid)
+			if group == "" || path == group || strings.HasPrefix(path, group+"/") {
+				rids = append(rids, rid)
 			}
 		}
+		return rids
 	}
+	name := strings.TrimPrefix(strings.TrimPrefix(group, savedGroup), "/")
+	found := make(map[int]bool)
+	for _, search := range d.vault.SavedSearches() {
+		if name != "" && search.Name != name {
+			continue
+		}
+		q, err := search.Parse()
+		if err != nil {
+			log.Printf("ERROR: saved search '%s', %v", search.Name, err)
+			continue
+		}
+		for _, m := range d.vault.Find(q) {
+			found[m.ID] = true
+		}
+	}
+	for rid := range found {
+		rids = append(rids, rid)
+	}
+	sort.Ints(rids)
+	return rids
 }
 
-// helper function to print record
-func printRecord(pid int, entry gokeepasslib.Entry) {
-	fmt.Printf("---\n")
-	fmt.Printf("Record   %d\n", pid)
-	fmt.Printf("Title    %s\n", getValue(entry, "Title"))
-	fmt.Printf("Login    %s\n", getValue(entry, "Login"))
-	fmt.Printf("UserName %s\n", getValue(entry, "UserName"))
-	fmt.Printf("URL      %s\n", getValue(entry, "URL"))
-	fmt.Printf("Notes    %s\n", getValue(entry, "Notes"))
-	fmt.Printf("Tags     %s\n", entry.Tags)
+// helper function to list db records, optionally within given group
+func (d *kdb) listRecords(group string, opts outputOptions) int {
+	rids := d.groupRecords(group)
+	err := d.printRecords(rids, opts, func(rid int, entry gokeepasslib.Entry) {
+		fmt.Printf("%-4s %s/%s\n", d.label(rid), d.vault.Group(rid), entry.GetTitle())
+	})
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+	return len(rids)
 }
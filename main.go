@@ -11,7 +11,11 @@ import (
 	"os"
 	"os/user"
 	"runtime"
+	"syscall"
 	"time"
+
+	"github.com/vkuznet/kpass/vault"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 // version of the code
@@ -20,7 +24,7 @@ var gitVersion, gitTag string
 // ecmInfo function returns version string of the server
 func kpassInfo() string {
 	goVersion := runtime.Version()
-	tstamp := time.Now().Format("2006-02-01")
+	tstamp := time.Now().Format("2006-01-02")
 	return fmt.Sprintf("kpass git=%s tag=%s go=%s date=%s", gitVersion, gitTag, goVersion, tstamp)
 }
 
@@ -37,17 +41,10 @@ func cmdUsage(dbPath string) {
 	}
 	fmt.Println()
 	fmt.Println("KeePass DB commands :")
-	fmt.Println("cp <ID> <attribute> # copy record ID attribute to cpilboard")
-	fmt.Println("rm <ID>             # remove record ID from database")
-	fmt.Println("add <key>           # add specific record key")
-	fmt.Println("save                # save record in DB and write new DB file")
-	fmt.Println("timeout             # show current timeout settings")
-	fmt.Println("timeout <int>       # set timeout interval in seconds")
+	printCommands(false, false)
 	fmt.Println()
 	fmt.Println("Additional commands :")
-	fmt.Println("encrypt <fname>     # encrypt given file")
-	fmt.Println("decrypt <fname>     # decrypt given file")
-	fmt.Println("help                # show this message")
+	printCommands(false, true)
 }
 
 // main function
@@ -66,35 +63,125 @@ func main() {
 	flag.BoolVar(&version, "version", false, "show version")
 	var cipher string
 	flag.StringVar(&cipher, "cipher", "aes", "cipher to use (aes, nacl)")
+	flag.StringVar(&outputFormat, "format", "", "output format of listing and lookup commands (json, jsonl, yaml, table)")
+	flag.StringVar(&searchMode, "search-mode", vault.ModeLiteral, "default search mode (literal, fuzzy, regex, glob)")
 	var dfile string
 	flag.StringVar(&dfile, "decrypt", "", "decrypt given file")
 	var efile string
 	flag.StringVar(&efile, "encrypt", "", "encrypt given file")
+	flag.IntVar(&clipboardClear, "clipboard-clear", 0, "clear clipboard after given number of seconds (0 disables it), scripts and -pwd wait for it before exit")
+	flag.StringVar(&pwdSource.File, "password-file", "", "read master password from first line of given file")
+	flag.IntVar(&pwdSource.Fd, "password-fd", -1, "read master password from given file descriptor")
+	flag.StringVar(&pwdSource.Env, "password-env", "", "read master password from given environment variable (insecure)")
+	flag.StringVar(&pwdSource.Cmd, "password-cmd", "", "read master password from stdout of given command, e.g. hardware token helper")
+	var scriptFile string
+	flag.StringVar(&scriptFile, "script", "", "execute commands of given script file ('-' for stdin) and write changes once at the end")
+	var continueOnError bool
+	flag.BoolVar(&continueOnError, "continue-on-error", false, "continue script execution on error")
+	var config string
+	flag.StringVar(&config, "config", configPath(), "configuration file")
+	var profile string
+	flag.StringVar(&profile, "profile", "", "configuration profile to use (default profile of configuration file if empty)")
 	flag.Usage = func() {
-		fmt.Println("Usage: kpass [options]")
+		fmt.Println("Usage: kpass [options] [command] [arguments]")
 		flag.PrintDefaults()
+		fmt.Println()
+		fmt.Println("Options can be also set via KPASS_<OPTION> environment variables, e.g. KPASS_KDBX,")
+		fmt.Println("or via profiles of configuration file. The precedence is option > environment > profile.")
 		cmdUsage("")
+		commandsUsage()
 	}
 	flag.Parse()
+	secureMemory()
+
+	// version does not depend on configuration
 	if version {
 		fmt.Println(kpassInfo())
 		os.Exit(0)
 	}
 
+	// apply settings from configuration file and environment
+	cfg, err := loadConfig(lookupSetting(flag.CommandLine, "config", config))
+	if err != nil {
+		fmt.Println("ERROR:", err)
+		os.Exit(exitUsage)
+	}
+	prof, err := cfg.profile(lookupSetting(flag.CommandLine, "profile", profile))
+	if err == nil {
+		err = applyConfig(flag.CommandLine, prof)
+	}
+	if err == nil {
+		err = setHooks(cfg.Hooks, prof.Hooks)
+	}
+	if err != nil {
+		fmt.Println("ERROR:", err)
+		os.Exit(exitUsage)
+	}
+	if err := pwdSource.check(); err != nil {
+		fmt.Println("ERROR:", err)
+		os.Exit(exitUsage)
+	}
+
 	// generate password if asked
 	if pwd != "" {
-		genPassword(pwd)
+		if err := genPassword(pwd); err != nil {
+			fatal(err)
+		}
+		waitClipboard()
 		return
 	}
 	// decrypt given file
 	if dfile != "" {
-		decryptFile(dfile, kfile, cipher)
+		if err := decryptFile(dfile, kfile, cipher, pwdSource.read); err != nil {
+			fatal(err)
+		}
 		return
 	}
 	// encrypt given file
 	if efile != "" {
-		encryptFile(efile, kfile, cipher)
+		if err := encryptFile(efile, kfile, cipher, pwdSource.read); err != nil {
+			fatal(err)
+		}
 		return
 	}
-	manageKeePass(kpath, kfile, pwd, cipher, interval)
+	if err := checkFormat(outputFormat); err != nil {
+		fmt.Println(err)
+		os.Exit(exitUsage)
+	}
+	if err := vault.CheckMode(searchMode); err != nil {
+		fmt.Println(err)
+		os.Exit(exitUsage)
+	}
+	// print shell completion script or completion candidates
+	if flag.Arg(0) == "completion" {
+		if flag.NArg() != 2 {
+			fatal(fmt.Errorf("%w, usage: kpass completion bash|zsh|fish", errUsage))
+		}
+		if err := printCompletion(flag.Arg(1)); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	// run full-screen terminal UI
+	if flag.Arg(0) == "tui" {
+		exit(runTUI(kpath, kfile, interval))
+	}
+	if flag.Arg(0) == "__complete" {
+		printCandidates(cfg, flag.Arg(1))
+		return
+	}
+	// run non-interactive command if it is given
+	if flag.NArg() > 0 {
+		exit(runCommand(kpath, kfile, flag.Args()))
+	}
+	// run script if it is given or commands are piped via stdin
+	if scriptFile == "" && !terminal.IsTerminal(int(syscall.Stdin)) {
+		scriptFile = "-"
+	}
+	if scriptFile != "" {
+		code := runScript(kpath, kfile, cipher, scriptFile, continueOnError)
+		waitClipboard()
+		exit(code)
+	}
+	manageKeePass(kpath, kfile, cipher, interval)
 }
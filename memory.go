@@ -0,0 +1,111 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/vkuznet/kpass/vault"
+)
+
+// secrets keeps track of all sensitive buffers which should be wiped on exit
+var secrets [][]byte
+
+// secretsLock protects secrets slice
+var secretsLock sync.Mutex
+
+// onWipe holds functions to call before sensitive buffers are wiped,
+// e.g. to re-lock protected entries of the database
+var onWipe []func()
+
+// exitFuncs holds functions to call before kpass exits, e.g. to restore
+// terminal state
+var exitFuncs []func()
+
+// helper function to register function which should be called on exit
+func atExit(f func()) {
+	exitFuncs = append(exitFuncs, f)
+}
+
+// keep track if we already warned about mlock failures
+var mlockWarned bool
+
+// helper function to lock sensitive buffer in memory, failure is reported
+// only once since it applies to all buffers
+func lockMemory(buf []byte) {
+	if err := vault.LockMemory(buf); err != nil && !mlockWarned {
+		mlockWarned = true
+		log.Printf("WARNING: %v", err)
+	}
+}
+
+// helper function to wipe sensitive buffer and unlock its memory
+func releaseSecret(buf []byte) {
+	vault.Wipe(buf)
+	vault.UnlockMemory(buf)
+}
+
+// helper function to register sensitive buffer, it will be locked in memory
+// (if supported by the platform) and wiped on exit
+func keepSecret(buf []byte) []byte {
+	if len(buf) == 0 {
+		return buf
+	}
+	lockMemory(buf)
+	secretsLock.Lock()
+	secrets = append(secrets, buf)
+	secretsLock.Unlock()
+	return buf
+}
+
+// helper function to register function which should be called before
+// sensitive buffers are wiped
+func onWipeSecrets(f func()) {
+	secretsLock.Lock()
+	onWipe = append(onWipe, f)
+	secretsLock.Unlock()
+}
+
+// helper function to wipe and release all registered sensitive buffers
+func wipeSecrets() {
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	for _, f := range onWipe {
+		f()
+	}
+	onWipe = nil
+	for _, buf := range secrets {
+		releaseSecret(buf)
+	}
+	secrets = nil
+}
+
+// helper function to wipe sensitive data and exit with given code
+func exit(code int) {
+	for _, f := range exitFuncs {
+		f()
+	}
+	wipeSecrets()
+	os.Exit(code)
+}
+
+// helper function to setup memory protection of kpass process, it disables
+// core dumps and wipes sensitive data upon interrupt or termination signals
+func secureMemory() {
+	if err := vault.DisableCoreDumps(); err != nil {
+		log.Printf("WARNING: %v", err)
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		<-sig
+		exit(1)
+	}()
+}
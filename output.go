@@ -0,0 +1,286 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	gokeepasslib "github.com/tobischo/gokeepasslib/v3"
+	wrappers "github.com/tobischo/gokeepasslib/v3/wrappers"
+	"github.com/vkuznet/kpass/vault"
+	"golang.org/x/crypto/ssh/terminal"
+	"gopkg.in/yaml.v3"
+)
+
+// outputFormat represents default output format of records
+var outputFormat string
+
+// outputFormats lists supported output formats
+var outputFormats = []string{"json", "jsonl", "yaml", "table"}
+
+// outputOptions represents output options of listing and lookup commands
+type outputOptions struct {
+	Format string // output format, empty for human readable one
+	Reveal bool   // include protected fields
+}
+
+// RecordTimes represents times of db record
+type RecordTimes struct {
+	Created  *time.Time `json:"created,omitempty" yaml:"created,omitempty"`
+	Modified *time.Time `json:"modified,omitempty" yaml:"modified,omitempty"`
+	Accessed *time.Time `json:"accessed,omitempty" yaml:"accessed,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty" yaml:"expires,omitempty"`
+}
+
+// RecordInfo represents structured db record used in machine readable output
+type RecordInfo struct {
+	DB     string            `json:"database,omitempty" yaml:"database,omitempty"`
+	ID     int               `json:"id" yaml:"id"`
+	UUID   string            `json:"uuid" yaml:"uuid"`
+	Group  string            `json:"group" yaml:"group"`
+	Title  string            `json:"title" yaml:"title"`
+	Fields map[string]string `json:"fields" yaml:"fields"`
+	Tags   []string          `json:"tags" yaml:"tags"`
+	Times  RecordTimes       `json:"times" yaml:"times"`
+	// Matched lists fields matched by search query
+	Matched []string `json:"matched,omitempty" yaml:"matched,omitempty"`
+}
+
+// helper function to parse output options from command arguments, it
+// returns output options and remaining arguments
+func parseOptions(args []string) (outputOptions, []string, error) {
+	opts := outputOptions{Format: outputFormat}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--reveal" || arg == "-reveal" {
+			opts.Reveal = true
+		} else if arg == "--format" || arg == "-format" {
+			if i+1 == len(args) {
+				return opts, rest, fmt.Errorf("missing value of %s option", arg)
+			}
+			i++
+			opts.Format = args[i]
+		} else if strings.HasPrefix(arg, "--format=") || strings.HasPrefix(arg, "-format=") {
+			opts.Format = arg[strings.Index(arg, "=")+1:]
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	if err := checkFormat(opts.Format); err != nil {
+		return opts, rest, err
+	}
+	return opts, rest, nil
+}
+
+// helper function to check output format
+func checkFormat(format string) error {
+	if format == "" {
+		return nil
+	}
+	for _, f := range outputFormats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported output format '%s', supported formats: %s",
+		format, strings.Join(outputFormats, ","))
+}
+
+// helper function to convert time wrapper into time pointer
+func recordTime(t *wrappers.TimeWrapper) *time.Time {
+	if t == nil {
+		return nil
+	}
+	val := t.Time
+	return &val
+}
+
+// helper function to create record info of given db record, protected
+// fields are only included if reveal flag is set
+func (d *kdb) recordInfo(rid int, entry gokeepasslib.Entry, reveal bool) (RecordInfo, error) {
+	info := RecordInfo{
+		ID:     rid,
+		UUID:   fmt.Sprintf("%x", entry.UUID[:]),
+		Group:  d.vault.Group(rid),
+		Title:  entry.GetTitle(),
+		Fields: make(map[string]string),
+		Tags:   []string{},
+		Times: RecordTimes{
+			Created:  recordTime(entry.Times.CreationTime),
+			Modified: recordTime(entry.Times.LastModificationTime),
+			Accessed: recordTime(entry.Times.LastAccessTime),
+		},
+	}
+	if d.prefix != "" {
+		info.DB = d.name
+	}
+	if entry.Times.Expires.Bool {
+		info.Times.Expires = recordTime(entry.Times.ExpiryTime)
+	}
+	for _, tag := range strings.FieldsFunc(entry.Tags, func(r rune) bool { return r == ',' || r == ';' }) {
+		info.Tags = append(info.Tags, strings.TrimSpace(tag))
+	}
+	for _, val := range entry.Values {
+		if vault.IsProtected(val) {
+			if !reveal {
+				continue
+			}
+			data, err := d.vault.Reveal(entry, val.Key)
+			if err != nil {
+				return info, err
+			}
+			info.Fields[val.Key] = string(data)
+			vault.Wipe(data)
+			continue
+		}
+		info.Fields[val.Key] = val.Value.Content
+	}
+	return info, nil
+}
+
+// helper function to collect structured records of given record IDs
+func (d *kdb) collectRecords(rids []int, reveal bool) ([]RecordInfo, error) {
+	var records []RecordInfo
+	for _, rid := range rids {
+		entry, _ := d.vault.Entry(rid)
+		info, err := d.recordInfo(rid, entry, reveal)
+		if err != nil {
+			return records, err
+		}
+		records = append(records, info)
+	}
+	return records, nil
+}
+
+// helper function to print given records in requested format, the human
+// function is used to print records when no output format is given
+func (d *kdb) printRecords(rids []int, opts outputOptions, human func(int, gokeepasslib.Entry)) error {
+	if opts.Format == "" {
+		for _, rid := range rids {
+			entry, _ := d.vault.Entry(rid)
+			human(rid, entry)
+		}
+		return nil
+	}
+	records, err := d.collectRecords(rids, opts.Reveal)
+	if err != nil {
+		return err
+	}
+	return writeRecords(records, opts.Format)
+}
+
+// helper function to write structured records in given format to stdout
+func writeRecords(records []RecordInfo, format string) error {
+	if records == nil {
+		records = []RecordInfo{}
+	}
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case "jsonl":
+		enc := json.NewEncoder(os.Stdout)
+		for _, rec := range records {
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "yaml":
+		enc := yaml.NewEncoder(os.Stdout)
+		defer enc.Close()
+		return enc.Encode(records)
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tGROUP\tTITLE\tUSERNAME\tURL\tTAGS")
+		for _, rec := range records {
+			rid := strconv.Itoa(rec.ID)
+			if rec.DB != "" {
+				rid = fmt.Sprintf("%s:%d", rec.DB, rec.ID)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", rid, rec.Group, rec.Title,
+				rec.Fields["UserName"], rec.Fields["URL"], strings.Join(rec.Tags, ","))
+		}
+		return w.Flush()
+	}
+	return checkFormat(format)
+}
+
+// highlight markers of matched parts of field values
+const (
+	highlightStart = "\033[1;7m"
+	highlightEnd   = "\033[0m"
+)
+
+// helper function to highlight given spans of the value, highlighting is
+// used only if stdout is a terminal
+func highlight(val string, spans []vault.Span) string {
+	if len(spans) == 0 || !terminal.IsTerminal(int(os.Stdout.Fd())) {
+		return val
+	}
+	var sb strings.Builder
+	pos := 0
+	for _, sp := range spans {
+		if sp.Start < pos || sp.End > len(val) || sp.Start == sp.End {
+			continue
+		}
+		sb.WriteString(val[pos:sp.Start])
+		sb.WriteString(highlightStart + val[sp.Start:sp.End] + highlightEnd)
+		pos = sp.End
+	}
+	sb.WriteString(val[pos:])
+	return sb.String()
+}
+
+// helper function to return names of matched fields
+func matchedFields(m vault.Match) []string {
+	var keys []string
+	for _, fm := range m.Fields {
+		if !inList(fm.Key, keys) {
+			keys = append(keys, fm.Key)
+		}
+	}
+	return keys
+}
+
+// helper function to print search match of db record, matched parts of
+// field values are highlighted and matched fields not shown by printRecord
+// are printed as well (protected ones are masked)
+func (d *kdb) printMatch(m vault.Match, entry gokeepasslib.Entry) {
+	spans := make(map[string][]vault.Span)
+	shown := []string{"Title", "Login", "UserName", "URL", "Notes", "Tags"}
+	for _, fm := range m.Fields {
+		spans[fm.Key] = append(spans[fm.Key], fm.Spans...)
+	}
+	fmt.Printf("---\n")
+	fmt.Printf("Record   %s\n", d.label(m.ID))
+	for _, key := range shown {
+		val := getValue(entry, key)
+		if key == "Tags" {
+			val = entry.Tags
+		}
+		fmt.Printf("%-8s %s\n", key, highlight(val, spans[key]))
+	}
+	for _, fm := range m.Fields {
+		if inList(fm.Key, shown) {
+			continue
+		}
+		val := highlight(fm.Value, fm.Spans)
+		if fm.Protected {
+			val = "********"
+		}
+		fmt.Printf("%-8s %s\n", fm.Key, val)
+	}
+	fmt.Printf("Matched  %s\n", strings.Join(matchedFields(m), ", "))
+}
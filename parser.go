@@ -0,0 +1,84 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"errors"
+	"strings"
+)
+
+// helper function to split input line into tokens, it supports shell-style
+// quoting: single quotes preserve literal value of all characters, double
+// quotes allow backslash escapes, and backslash outside of quotes escapes
+// next character
+func tokenize(line string) ([]string, error) {
+	tokens, _, err := tokenizeRaw(line)
+	return tokens, err
+}
+
+// helper function to split input line into tokens, see tokenize, it also
+// returns raw tokens as they appear in the line, i.e. with quotes and escapes
+func tokenizeRaw(line string) ([]string, []string, error) {
+	var tokens, raw []string
+	var token strings.Builder
+	inToken := false
+	start := 0
+	var quote rune
+	escape := false
+	for i, r := range line {
+		if !inToken {
+			start = i
+		}
+		if escape {
+			token.WriteRune(r)
+			escape = false
+			continue
+		}
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				token.WriteRune(r)
+			}
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else if r == '\\' {
+				escape = true
+			} else {
+				token.WriteRune(r)
+			}
+		case r == '\\':
+			escape = true
+			inToken = true
+		case r == '\'' || r == '"':
+			quote = r
+			inToken = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inToken {
+				tokens = append(tokens, token.String())
+				raw = append(raw, line[start:i])
+				token.Reset()
+				inToken = false
+			}
+		default:
+			token.WriteRune(r)
+			inToken = true
+		}
+	}
+	if escape {
+		return nil, nil, errors.New("unfinished escape sequence")
+	}
+	if quote != 0 {
+		return nil, nil, errors.New("unterminated quoted string")
+	}
+	if inToken {
+		tokens = append(tokens, token.String())
+		raw = append(raw, line[start:])
+	}
+	return tokens, raw, nil
+}
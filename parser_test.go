@@ -0,0 +1,49 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		line   string
+		tokens []string
+		fail   bool
+	}{
+		{line: "", tokens: nil},
+		{line: "   \t ", tokens: nil},
+		{line: "get 1", tokens: []string{"get", "1"}},
+		{line: "  ls   Root/Servers  ", tokens: []string{"ls", "Root/Servers"}},
+		{line: `add "title=My Bank" user=joe`, tokens: []string{"add", "title=My Bank", "user=joe"}},
+		{line: `add 'notes=a "quoted" \n text'`, tokens: []string{"add", `notes=a "quoted" \n text`}},
+		{line: `edit 1 "notes=say \"hi\""`, tokens: []string{"edit", "1", `notes=say "hi"`}},
+		{line: `ls My\ Group`, tokens: []string{"ls", "My Group"}},
+		{line: `get ""`, tokens: []string{"get", ""}},
+		{line: `a"b"'c'`, tokens: []string{"abc"}},
+		{line: "search O'Brien", fail: true},
+		{line: `search "unterminated`, fail: true},
+		{line: `get 1\`, fail: true},
+	}
+	for _, test := range tests {
+		tokens, err := tokenize(test.line)
+		if test.fail {
+			if err == nil {
+				t.Errorf("tokenize(%q) = %q, expected error", test.line, tokens)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("tokenize(%q) failed, %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(tokens, test.tokens) {
+			t.Errorf("tokenize(%q) = %q, expected %q", test.line, tokens, test.tokens)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"github.com/vkuznet/kpass/vault"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// PasswordSource represents non-interactive source of master password
+type PasswordSource struct {
+	File string // file to read password from
+	Fd   int    // file descriptor to read password from, -1 if not used
+	Env  string // environment variable holding password
+	Cmd  string // command which prints password to its stdout
+}
+
+// pwdSource holds master password source provided via command line options
+var pwdSource = PasswordSource{Fd: -1}
+
+// helper function to check that at most one password source is given
+func (p *PasswordSource) check() error {
+	nsrc := 0
+	for _, set := range []bool{p.File != "", p.Fd >= 0, p.Env != "", p.Cmd != ""} {
+		if set {
+			nsrc += 1
+		}
+	}
+	if nsrc > 1 {
+		return errors.New("only one of password-file, password-fd, password-env or password-cmd options can be used")
+	}
+	return nil
+}
+
+// helper function to read first line from given reader, the returned
+// byte slice should be wiped by the caller
+func readSecretLine(r io.Reader) ([]byte, error) {
+	reader := bufio.NewReader(r)
+	data, err := reader.ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		vault.Wipe(data)
+		return nil, err
+	}
+	pwd := append([]byte{}, bytes.TrimRight(data, "\r\n")...)
+	vault.Wipe(data)
+	return pwd, nil
+}
+
+// helper function to read master password from password file
+func (p *PasswordSource) fromFile() ([]byte, error) {
+	info, err := os.Stat(p.File)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		log.Printf("WARNING: password file %s is accessible by other users (mode %v), consider chmod 600", p.File, info.Mode().Perm())
+	}
+	file, err := os.Open(p.File)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readSecretLine(file)
+}
+
+// helper function to read master password from file descriptor
+func (p *PasswordSource) fromFd() ([]byte, error) {
+	file := os.NewFile(uintptr(p.Fd), fmt.Sprintf("fd%d", p.Fd))
+	if file == nil {
+		return nil, fmt.Errorf("invalid file descriptor %d", p.Fd)
+	}
+	defer file.Close()
+	return readSecretLine(file)
+}
+
+// helper function to read master password from environment variable, the
+// variable is removed from environment afterwards
+func (p *PasswordSource) fromEnv() ([]byte, error) {
+	val, ok := os.LookupEnv(p.Env)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", p.Env)
+	}
+	log.Printf("WARNING: reading password from environment variable %s is insecure, it may be visible to other processes", p.Env)
+	os.Unsetenv(p.Env)
+	return []byte(val), nil
+}
+
+// helper function to read master password from stdout of a command, e.g.
+// hardware token helper, the command inherits stdin and stderr to be able
+// to interact with the user
+func (p *PasswordSource) fromCmd() ([]byte, error) {
+	var stdout bytes.Buffer
+	cmd := shellCommand(p.Cmd)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	defer vault.Wipe(stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("password command failed, %v", err)
+	}
+	return readSecretLine(&stdout)
+}
+
+// read returns master password from configured source, if no source is
+// configured the password is read from the terminal
+func (p *PasswordSource) read(msg string) ([]byte, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	var pwd []byte
+	var err error
+	switch {
+	case p.File != "":
+		pwd, err = p.fromFile()
+	case p.Fd >= 0:
+		pwd, err = p.fromFd()
+	case p.Env != "":
+		pwd, err = p.fromEnv()
+	case p.Cmd != "":
+		pwd, err = p.fromCmd()
+	default:
+		return promptPassword(msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(pwd) == 0 {
+		return nil, errors.New("empty password")
+	}
+	return pwd, nil
+}
+
+// helper function to create command which runs given command line via
+// system shell
+func shellCommand(line string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", line)
+	}
+	return exec.Command("sh", "-c", line)
+}
+
+// helper function to read master password from the terminal
+func promptPassword(msg string) ([]byte, error) {
+	if !terminal.IsTerminal(int(syscall.Stdin)) {
+		return nil, errors.New("stdin is not a terminal, use password-file, password-fd, password-env or password-cmd option")
+	}
+	return readPassword(msg), nil
+}
@@ -0,0 +1,209 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/peterh/liner"
+	"github.com/vkuznet/kpass/vault"
+)
+
+// inputRequest represents request to read next input line
+type inputRequest struct {
+	prompt   string // prompt to show
+	password bool   // read password without echo
+	history  bool   // add input to history
+}
+
+// inputLine represents line read upon input request, password value is kept
+// in secret buffer which is owned and wiped by the receiver
+type inputLine struct {
+	text   string // input line
+	secret []byte // password value
+}
+
+// helper function to create line editor for given session, the history is
+// kept in memory only and it is never persisted to avoid leaking search terms
+func newLineEditor(s *session) *liner.State {
+	line := liner.NewLiner()
+	line.SetCtrlCAborts(true)
+	line.SetTabCompletionStyle(liner.TabPrints)
+	line.SetWordCompleter(s.complete)
+	atExit(func() {
+		line.ClearHistory()
+		line.Close()
+	})
+	return line
+}
+
+// helper function to read input lines upon request and send them over
+// provided channel
+func readInputChannel(line *liner.State, req <-chan inputRequest, ch chan<- inputLine) {
+	for r := range req {
+		if r.password {
+			pwd := readPassword(r.prompt)
+			// read password again to match it
+			pwd2 := readPassword("repeat password: ")
+			if bytes.Equal(pwd, pwd2) {
+				ch <- inputLine{secret: pwd}
+			} else {
+				vault.Wipe(pwd)
+				ch <- inputLine{text: "WARNING: password match failed, will discard it ..."}
+			}
+			vault.Wipe(pwd2)
+			continue
+		}
+		val, err := line.Prompt(r.prompt)
+		if errors.Is(err, io.EOF) {
+			fmt.Println()
+			ch <- inputLine{text: "exit"}
+			continue
+		} else if errors.Is(err, liner.ErrPromptAborted) {
+			ch <- inputLine{}
+			continue
+		} else if err != nil {
+			ch <- inputLine{text: fmt.Sprintf("WARNING: wrong input %v", err)}
+			continue
+		}
+		if r.history && strings.TrimSpace(val) != "" {
+			line.AppendHistory(val)
+		}
+		ch <- inputLine{text: val}
+	}
+}
+
+// helper function to quote completion candidate if necessary
+func quoteArg(arg string) string {
+	if strings.ContainsAny(arg, " \t'\"\\") {
+		arg = strings.ReplaceAll(arg, `\`, `\\`)
+		arg = strings.ReplaceAll(arg, `"`, `\"`)
+		return fmt.Sprintf(`"%s"`, arg)
+	}
+	return arg
+}
+
+// helper function to provide completion candidates of record IDs and paths
+// of all opened databases
+func (s *session) recordCandidates() []string {
+	var out []string
+	for _, d := range s.dbs {
+		rids := d.vault.IDs()
+		for _, rid := range rids {
+			out = append(out, d.label(rid))
+		}
+		for _, rid := range rids {
+			entry, _ := d.vault.Entry(rid)
+			out = append(out, quoteArg(fmt.Sprintf("%s%s/%s", d.prefix, d.vault.Group(rid), entry.GetTitle())))
+		}
+	}
+	return out
+}
+
+// helper function to provide completion candidates of group paths
+func (d *kdb) groupCandidates() []string {
+	groups := make(map[string]bool)
+	for _, rid := range d.vault.IDs() {
+		groups[d.vault.Group(rid)] = true
+	}
+	for _, search := range d.vault.SavedSearches() {
+		groups[savedGroup+"/"+search.Name] = true
+	}
+	var out []string
+	for path := range groups {
+		out = append(out, quoteArg(path))
+	}
+	sort.Strings(out)
+	return out
+}
+
+// helper function to provide completion candidates of fields of given record
+func (s *session) fieldCandidates(key string) []string {
+	d, key := s.resolve(key)
+	if _, entry, err := d.findRecord(key); err == nil {
+		var out []string
+		for _, val := range entry.Values {
+			out = append(out, quoteArg(val.Key))
+		}
+		return out
+	}
+	return []string{"Title", "UserName", "Password", "URL", "Notes", "Tags"}
+}
+
+// helper function to provide completion candidates of database aliases
+func (s *session) dbCandidates() []string {
+	var out []string
+	for _, d := range s.dbs {
+		out = append(out, d.name)
+	}
+	return out
+}
+
+// complete provides context-aware completion of command names, record IDs,
+// field names and group paths
+func (s *session) complete(line string, pos int) (string, []string, string) {
+	head, tail := line[:pos], line[pos:]
+	if s.active.collectKey != "" {
+		return head, nil, tail
+	}
+	idx := strings.LastIndexAny(head, " \t") + 1
+	prefix, word := head[:idx], head[idx:]
+	words, err := tokenize(prefix)
+	if err != nil {
+		return head, nil, tail
+	}
+
+	var candidates []string
+	if len(words) == 0 {
+		for _, cmd := range commandTable {
+			candidates = append(candidates, cmd.Name)
+			candidates = append(candidates, cmd.Aliases...)
+		}
+	} else if strings.HasPrefix(word, "-") {
+		candidates = []string{"--format", "--reveal"}
+	} else if len(words) > 1 && (words[len(words)-1] == "--format" || words[len(words)-1] == "-format") {
+		candidates = outputFormats
+	} else if cmd := findCommand(words[0]); cmd != nil {
+		var args []string
+		for _, w := range words[1:] {
+			if !strings.HasPrefix(w, "-") {
+				args = append(args, w)
+			}
+		}
+		switch cmd.Name {
+		case "cp", "get", "show", "rm", "edit":
+			if len(args) == 0 {
+				candidates = s.recordCandidates()
+			} else if cmd.Name == "edit" {
+				for _, field := range s.fieldCandidates(args[0]) {
+					candidates = append(candidates, field+"=")
+				}
+			} else if len(args) == 1 && cmd.Name != "show" && cmd.Name != "rm" {
+				candidates = s.fieldCandidates(args[0])
+			}
+		case "ls":
+			candidates = s.active.groupCandidates()
+		case "add":
+			candidates = s.fieldCandidates("")
+		case "use", "close":
+			candidates = s.dbCandidates()
+		}
+	}
+
+	var completions []string
+	for _, c := range candidates {
+		if strings.HasPrefix(strings.ToLower(c), strings.ToLower(word)) ||
+			strings.HasPrefix(strings.ToLower(strings.TrimPrefix(c, `"`)), strings.ToLower(word)) {
+			completions = append(completions, c)
+		}
+	}
+	return prefix, completions, tail
+}
@@ -0,0 +1,154 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/vkuznet/kpass/vault"
+)
+
+// varName represents valid name of script variable
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// script represents kpass script, i.e. set of REPL commands
+type script struct {
+	session         *session          // session commands are executed in
+	vars            map[string]string // script variables
+	continueOnError bool              // continue execution of script on error
+}
+
+// helper function to expand variables in given token, variables can be
+// referred as $name or ${name}, script variables take precedence over
+// environment ones and $$ is used for literal $ sign
+func (sc *script) expand(token string) (string, error) {
+	var err error
+	val := os.Expand(token, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		if val, ok := sc.vars[name]; ok {
+			return val
+		}
+		if val, ok := os.LookupEnv(name); ok {
+			return val
+		}
+		if err == nil {
+			err = fmt.Errorf("undefined variable '%s'", name)
+		}
+		return ""
+	})
+	return val, err
+}
+
+// helper function to split script line into tokens and raw tokens, see
+// tokenizeRaw, and expand variables in both of them
+func (sc *script) tokenize(line string) ([]string, []string, error) {
+	tokens, raw, err := tokenizeRaw(line)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range tokens {
+		if tokens[i], err = sc.expand(tokens[i]); err != nil {
+			return nil, nil, err
+		}
+		if raw[i], err = sc.expand(raw[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return tokens, raw, nil
+}
+
+// helper function to execute single script line
+func (sc *script) execute(line string) error {
+	tokens, raw, err := sc.tokenize(line)
+	if err != nil {
+		return err
+	}
+	if len(tokens) > 0 && tokens[0] == "set" {
+		if len(tokens) != 3 || !varName.MatchString(tokens[1]) {
+			return fmt.Errorf("%w, usage: set <name> <value>", errUsage)
+		}
+		sc.vars[tokens[1]] = tokens[2]
+		return nil
+	}
+	return sc.session.dispatch(tokens, raw)
+}
+
+// run executes script commands from given reader, it returns error of
+// first failed command
+func (sc *script) run(r io.Reader) error {
+	var failed error
+	scanner := bufio.NewScanner(r)
+	for nline := 1; scanner.Scan(); nline++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		err := sc.execute(line)
+		if errors.Is(err, errStop) {
+			break
+		}
+		if err != nil {
+			err = fmt.Errorf("line %d: %w", nline, err)
+			log.Printf("ERROR: %v", err)
+			if failed == nil {
+				failed = err
+			}
+			if !sc.continueOnError {
+				return failed
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return failed
+}
+
+// helper function to run kpass script, it opens database, executes script
+// commands and writes all database changes once at the end, if script fails
+// and continueOnError is not set no changes are written
+func runScript(kpath, kfile, cipher, fname string, continueOnError bool) int {
+	var r io.Reader = os.Stdin
+	if fname != "-" {
+		file, err := os.Open(fname)
+		if err != nil {
+			err = vault.FileError(err)
+			log.Printf("ERROR: %v", err)
+			return exitCode(err)
+		}
+		defer file.Close()
+		r = file
+	}
+	d, err := openDB(kpath, kfile, pwdSource.read)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return exitCode(err)
+	}
+	s := &session{kfile: kfile, cipher: cipher, script: true}
+	s.add(d)
+	sc := &script{session: s, vars: make(map[string]string), continueOnError: continueOnError}
+	failed := sc.run(r)
+	if failed != nil && !continueOnError {
+		log.Println("script failed, no changes were written")
+		return exitCode(failed)
+	}
+	for _, d := range s.dbs {
+		if err := d.commit(); err != nil {
+			log.Printf("ERROR: unable to write %s, %v", d.name, err)
+			return exitCode(err)
+		}
+	}
+	return exitCode(failed)
+}
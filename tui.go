@@ -0,0 +1,480 @@
+package main
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	gokeepasslib "github.com/tobischo/gokeepasslib/v3"
+	"github.com/vkuznet/kpass/vault"
+)
+
+// tuiHelp represents key bindings shown in status bar of TUI
+const tuiHelp = "[yellow]/[white] filter  [yellow]Tab[white] next pane  [yellow]u[white] copy username  [yellow]p[white] copy password  [yellow]l[white] copy URL  [yellow]e[white] edit  [yellow]d[white] delete  [yellow]Ctrl-L[white] lock  [yellow]q[white] quit"
+
+// tui represents full-screen terminal UI of kpass
+type tui struct {
+	d       *kdb // opened database
+	app     *tview.Application
+	pages   *tview.Pages
+	tree    *tview.TreeView // group tree pane
+	filter  *tview.InputField
+	list    *tview.Table    // entry list
+	details *tview.TextView // detail pane
+	status  *tview.TextView // status bar
+	group   string          // selected group
+	rids    []int           // record IDs shown in entry list
+
+	mutex    sync.Mutex
+	activity time.Time // time of last user activity
+	idle     bool      // UI was closed due to inactivity
+}
+
+// statusWriter writes log messages into TUI status bar, it is used by log
+// package and goroutines copying hook output, therefore status bar is only
+// updated from the event loop of the application
+type statusWriter struct {
+	app     *tview.Application
+	status  *tview.TextView
+	mutex   sync.Mutex
+	msg     string // last written message
+	pending bool   // update of status bar is queued
+}
+
+// Write implements io.Writer interface
+func (w *statusWriter) Write(data []byte) (int, error) {
+	msg := strings.TrimSpace(string(data))
+	// drop timestamp added by log package
+	if arr := strings.SplitN(msg, " ", 3); len(arr) == 3 && strings.Count(arr[1], ":") == 2 {
+		msg = arr[2]
+	}
+	w.mutex.Lock()
+	w.msg = msg
+	queue := !w.pending
+	w.pending = true
+	w.mutex.Unlock()
+	if queue {
+		// QueueUpdateDraw waits for the event loop which may be the caller,
+		// e.g. when database is written from key handler
+		go w.app.QueueUpdateDraw(func() {
+			w.mutex.Lock()
+			msg := w.msg
+			w.pending = false
+			w.mutex.Unlock()
+			w.status.SetText(tview.Escape(msg))
+		})
+	}
+	return len(data), nil
+}
+
+// helper function to restore log and hook output and stop terminal UI
+func (t *tui) stop() {
+	log.SetOutput(os.Stderr)
+	hookOutput = os.Stderr
+	t.app.Stop()
+}
+
+// helper function to run full-screen terminal UI
+func runTUI(kpath, kfile string, interval int) int {
+	d, err := openDB(kpath, kfile, pwdSource.read)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return exitCode(err)
+	}
+	t := newTUI(d)
+
+	// messages of database writes and hooks are shown in status bar
+	status := &statusWriter{app: t.app, status: t.status}
+	log.SetOutput(status)
+	hookOutput = status
+	defer t.stop()
+
+	// lock database after given interval of inactivity
+	go func() {
+		timeout := time.Duration(interval) * time.Second
+		for range time.Tick(time.Second) {
+			t.mutex.Lock()
+			idle := time.Since(t.activity) > timeout
+			t.idle = idle
+			t.mutex.Unlock()
+			if idle {
+				// outputs are restored once Run returns on main goroutine
+				t.app.Stop()
+				return
+			}
+		}
+	}()
+	if err := t.app.Run(); err != nil {
+		t.stop()
+		log.Printf("ERROR: %v", err)
+		return exitError
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.idle {
+		fmt.Printf("Exit after %ds of inactivity\n", interval)
+	}
+	return exitOK
+}
+
+// helper function to create terminal UI of given database
+func newTUI(d *kdb) *tui {
+	t := &tui{d: d, app: tview.NewApplication(), activity: time.Now()}
+
+	t.tree = tview.NewTreeView()
+	t.tree.SetBorder(true).SetTitle(" Groups ")
+	t.tree.SetSelectedFunc(func(node *tview.TreeNode) {
+		t.group, _ = node.GetReference().(string)
+		t.refresh()
+		t.app.SetFocus(t.list)
+	})
+
+	t.filter = tview.NewInputField().SetLabel("Filter: ")
+	t.filter.SetChangedFunc(func(string) { t.refresh() })
+	t.filter.SetDoneFunc(func(tcell.Key) { t.app.SetFocus(t.list) })
+
+	t.list = tview.NewTable().SetSelectable(true, false).SetFixed(1, 0)
+	t.list.SetBorder(true).SetTitle(" Entries ")
+	t.list.SetSelectionChangedFunc(func(row, col int) { t.showDetails() })
+	t.list.SetInputCapture(t.listKeys)
+
+	t.details = tview.NewTextView().SetDynamicColors(true).SetWrap(true)
+	t.details.SetBorder(true).SetTitle(" Details ")
+
+	t.status = tview.NewTextView().SetDynamicColors(true)
+	t.status.SetText(tuiHelp)
+
+	middle := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(t.filter, 1, 0, false).
+		AddItem(t.list, 0, 1, true)
+	panes := tview.NewFlex().
+		AddItem(t.tree, 0, 1, false).
+		AddItem(middle, 0, 2, true).
+		AddItem(t.details, 0, 2, false)
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(panes, 0, 1, true).
+		AddItem(t.status, 1, 0, false)
+	t.pages = tview.NewPages().AddPage("main", layout, true, true)
+
+	t.app.SetRoot(t.pages, true).SetFocus(t.list)
+	t.app.SetInputCapture(t.globalKeys)
+	t.buildTree()
+	t.refresh()
+	return t
+}
+
+// helper function to handle application wide key bindings
+func (t *tui) globalKeys(event *tcell.EventKey) *tcell.EventKey {
+	t.mutex.Lock()
+	t.activity = time.Now()
+	t.mutex.Unlock()
+	if name, _ := t.pages.GetFrontPage(); name != "main" {
+		return event
+	}
+	switch event.Key() {
+	case tcell.KeyCtrlL:
+		t.lock()
+		return nil
+	case tcell.KeyTab:
+		switch {
+		case t.tree.HasFocus():
+			t.app.SetFocus(t.list)
+		case t.list.HasFocus():
+			t.app.SetFocus(t.details)
+		default:
+			t.app.SetFocus(t.tree)
+		}
+		return nil
+	}
+	if t.filter.HasFocus() {
+		return event
+	}
+	switch event.Rune() {
+	case 'q':
+		t.stop()
+		return nil
+	case '/':
+		t.app.SetFocus(t.filter)
+		return nil
+	}
+	return event
+}
+
+// helper function to handle key bindings of entry list
+func (t *tui) listKeys(event *tcell.EventKey) *tcell.EventKey {
+	rid, ok := t.selected()
+	if !ok {
+		return event
+	}
+	switch event.Rune() {
+	case 'u':
+		t.copy(rid, "username")
+	case 'p':
+		t.copy(rid, "password")
+	case 'l':
+		t.copy(rid, "url")
+	case 'e':
+		t.edit(rid)
+	case 'd':
+		t.remove(rid)
+	default:
+		return event
+	}
+	return nil
+}
+
+// helper function to build group tree
+func (t *tui) buildTree() {
+	root := tview.NewTreeNode("All").SetReference("")
+	nodes := make(map[string]*tview.TreeNode)
+	var paths []string
+	for _, rid := range t.d.vault.IDs() {
+		path := t.d.vault.Group(rid)
+		if _, ok := nodes[path]; !ok {
+			nodes[path] = nil
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		parent := root
+		arr := strings.Split(path, "/")
+		for i := range arr {
+			sub := strings.Join(arr[:i+1], "/")
+			node := nodes[sub]
+			if node == nil {
+				node = tview.NewTreeNode(arr[i]).SetReference(sub)
+				nodes[sub] = node
+				parent.AddChild(node)
+			}
+			parent = node
+		}
+	}
+	if searches := t.d.vault.SavedSearches(); len(searches) > 0 {
+		saved := tview.NewTreeNode(savedGroup).SetReference(savedGroup)
+		for _, search := range searches {
+			saved.AddChild(tview.NewTreeNode(search.Name).SetReference(savedGroup + "/" + search.Name))
+		}
+		root.AddChild(saved)
+	}
+	t.tree.SetRoot(root).SetCurrentNode(root)
+}
+
+// helper function to check if given record matches filter of entry list,
+// members holds records of selected group
+func (t *tui) match(rid int, entry gokeepasslib.Entry, members map[int]bool) bool {
+	path := t.d.vault.Group(rid)
+	if !members[rid] {
+		return false
+	}
+	query := strings.ToLower(strings.TrimSpace(t.filter.GetText()))
+	if query == "" {
+		return true
+	}
+	for _, val := range []string{entry.GetTitle(), getValue(entry, "UserName"),
+		getValue(entry, "URL"), entry.Tags, path} {
+		if strings.Contains(strings.ToLower(val), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to refresh entry list
+func (t *tui) refresh() {
+	row, _ := t.list.GetSelection()
+	t.list.Clear()
+	for col, name := range []string{"ID", "Title", "UserName", "Group"} {
+		t.list.SetCell(0, col, tview.NewTableCell(name).
+			SetTextColor(tcell.ColorYellow).SetSelectable(false))
+	}
+	t.rids = nil
+	members := make(map[int]bool)
+	for _, rid := range t.d.groupRecords(t.group) {
+		members[rid] = true
+	}
+	for _, rid := range t.d.vault.IDs() {
+		entry, _ := t.d.vault.Entry(rid)
+		if !t.match(rid, entry, members) {
+			continue
+		}
+		t.rids = append(t.rids, rid)
+		n := len(t.rids)
+		t.list.SetCell(n, 0, tview.NewTableCell(strconv.Itoa(rid)))
+		t.list.SetCell(n, 1, tview.NewTableCell(tview.Escape(entry.GetTitle())).SetExpansion(1))
+		t.list.SetCell(n, 2, tview.NewTableCell(tview.Escape(getValue(entry, "UserName"))).SetExpansion(1))
+		t.list.SetCell(n, 3, tview.NewTableCell(tview.Escape(t.d.vault.Group(rid))))
+	}
+	if row < 1 {
+		row = 1
+	}
+	if row > len(t.rids) {
+		row = len(t.rids)
+	}
+	t.list.Select(row, 0)
+	t.list.SetTitle(fmt.Sprintf(" Entries (%d) ", len(t.rids)))
+	t.showDetails()
+}
+
+// helper function to return record ID of selected entry
+func (t *tui) selected() (int, bool) {
+	row, _ := t.list.GetSelection()
+	if row < 1 || row > len(t.rids) {
+		return 0, false
+	}
+	return t.rids[row-1], true
+}
+
+// helper function to show details of selected entry, protected fields are
+// masked
+func (t *tui) showDetails() {
+	rid, ok := t.selected()
+	if !ok {
+		t.details.SetText("")
+		return
+	}
+	entry, _ := t.d.vault.Entry(rid)
+	var out strings.Builder
+	fmt.Fprintf(&out, "[yellow]%-9s[white] %s\n", "Record", t.d.label(rid))
+	fmt.Fprintf(&out, "[yellow]%-9s[white] %s\n", "Group", tview.Escape(t.d.vault.Group(rid)))
+	for _, val := range entry.Values {
+		fmt.Fprintf(&out, "[yellow]%-9s[white] %s\n", tview.Escape(val.Key), tview.Escape(getValue(entry, val.Key)))
+	}
+	fmt.Fprintf(&out, "[yellow]%-9s[white] %s\n", "Tags", tview.Escape(entry.Tags))
+	if ts := recordTime(entry.Times.LastModificationTime); ts != nil {
+		fmt.Fprintf(&out, "[yellow]%-9s[white] %s\n", "Modified", ts.Format(time.RFC3339))
+	}
+	t.details.SetText(out.String()).ScrollToBeginning()
+}
+
+// helper function to copy attribute of given record to clipboard
+func (t *tui) copy(rid int, attr string) {
+	if err := t.d.clipboardCopy(strconv.Itoa(rid), attr); err != nil {
+		t.status.SetText(tview.Escape(fmt.Sprintf("ERROR: %v", err)))
+		return
+	}
+	t.status.SetText(fmt.Sprintf("%s copied to clipboard", attr))
+}
+
+// helper function to show edit form of given record
+func (t *tui) edit(rid int) {
+	entry, _ := t.d.vault.Entry(rid)
+	fields := []string{"Title", "UserName", "URL", "Notes"}
+	form := tview.NewForm()
+	for _, key := range fields {
+		form.AddInputField(key, getValue(entry, key), 50, nil, nil)
+	}
+	form.AddInputField("Tags", entry.Tags, 50, nil, nil)
+	form.AddPasswordField("Password", "", 50, '*', nil)
+	form.AddButton("Save", func() {
+		rec := make(vault.Record)
+		for _, key := range append(fields, "Tags") {
+			val := form.GetFormItemByLabel(key).(*tview.InputField).GetText()
+			if key == "Tags" && val != entry.Tags || key != "Tags" && val != getValue(entry, key) {
+				rec[key] = val
+			}
+		}
+		// empty password field keeps existing password
+		if pwd := form.GetFormItemByLabel("Password").(*tview.InputField).GetText(); pwd != "" {
+			rec["password"] = pwd
+		}
+		t.pages.RemovePage("edit")
+		if len(rec) == 0 {
+			t.status.SetText("no changes")
+			return
+		}
+		if err := t.d.editRecord(strconv.Itoa(rid), rec); err != nil {
+			t.status.SetText(tview.Escape(fmt.Sprintf("ERROR: %v", err)))
+			return
+		}
+		t.buildTree()
+		t.refresh()
+	})
+	form.AddButton("Cancel", func() { t.pages.RemovePage("edit") })
+	form.SetCancelFunc(func() { t.pages.RemovePage("edit") })
+	form.SetBorder(true).SetTitle(fmt.Sprintf(" Edit record %s (empty password keeps current one) ", t.d.label(rid)))
+	t.pages.AddPage("edit", modal(form, 80, 17), true, true)
+}
+
+// helper function to ask confirmation and delete given record
+func (t *tui) remove(rid int) {
+	entry, _ := t.d.vault.Entry(rid)
+	dialog := tview.NewModal().
+		SetText(fmt.Sprintf("Delete record %s %s?", t.d.label(rid), entry.GetTitle())).
+		AddButtons([]string{"Delete", "Cancel"}).
+		SetDoneFunc(func(idx int, label string) {
+			t.pages.RemovePage("delete")
+			if label != "Delete" {
+				return
+			}
+			if err := t.d.removeRecord(strconv.Itoa(rid)); err != nil {
+				t.status.SetText(tview.Escape(fmt.Sprintf("ERROR: %v", err)))
+				return
+			}
+			t.buildTree()
+			t.refresh()
+		})
+	t.pages.AddPage("delete", dialog, true, true)
+}
+
+// helper function to lock the database and ask its password to unlock it,
+// the database is not locked if it has unsaved changes
+func (t *tui) lock() {
+	if t.d.vault.Dirty() {
+		t.status.SetText("ERROR: database has unsaved changes and can't be locked")
+		return
+	}
+	t.d.lock()
+	t.rids = nil
+	t.list.Clear()
+	t.details.Clear()
+	t.tree.SetRoot(nil)
+
+	title := " Database is locked, enter password or Esc to quit "
+	field := tview.NewInputField().SetLabel("db password: ").SetMaskCharacter('*')
+	field.SetBorder(true).SetTitle(title)
+	field.SetDoneFunc(func(key tcell.Key) {
+		switch key {
+		case tcell.KeyEscape:
+			t.stop()
+		case tcell.KeyEnter:
+			pwd := []byte(field.GetText())
+			field.SetText("")
+			if err := t.d.unlock(pwd); err != nil {
+				field.SetTitle(tview.Escape(fmt.Sprintf(" ERROR: %v ", err)))
+				return
+			}
+			t.pages.RemovePage("lock")
+			t.pages.ShowPage("main")
+			t.status.SetText(tuiHelp)
+			t.buildTree()
+			t.refresh()
+			t.app.SetFocus(t.list)
+		}
+	})
+	t.pages.HidePage("main")
+	t.pages.AddPage("lock", modal(field, 80, 3), true, true)
+}
+
+// helper function to center given primitive on the screen
+func modal(p tview.Primitive, width, height int) tview.Primitive {
+	return tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(p, height, 1, true).
+			AddItem(nil, 0, 1, false), width, 1, true).
+		AddItem(nil, 0, 1, false)
+}
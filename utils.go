@@ -6,16 +6,15 @@ package main
 //
 
 import (
-	"bufio"
+	"bytes"
 	"fmt"
-	"log"
 	"os"
-	"strconv"
-	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/atotto/clipboard"
+	"github.com/vkuznet/kpass/vault"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
@@ -33,105 +32,106 @@ func sizeFormat(val int64) string {
 	return fmt.Sprintf("%v (%3.1f%s)", val, size, xlist[len(xlist)])
 }
 
-// helper function to read from stdin
-func readInput() (string, error) {
-	reader := bufio.NewReader(os.Stdin)
-	val, err := reader.ReadString('\n')
-	return val, err
-}
-
-// helper function to get password from stdin
-func readPassword(msg string) string {
+// helper function to get password from stdin, the returned byte slice
+// should be wiped by the caller once it is no longer needed
+func readPassword(msg string) []byte {
+	// prompts are written to stderr to keep stdout clean for command output
 	if msg != "" {
-		fmt.Print(msg)
+		fmt.Fprint(os.Stderr, msg)
 	}
 	bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
 	if err == nil {
-		fmt.Println("")
+		fmt.Fprintln(os.Stderr, "")
 	} else {
 		fmt.Println("\nError in ReadPassword", err)
-		os.Exit(1)
+		exit(1)
 	}
-	password := string(bytePassword)
-	return strings.TrimSpace(password)
-}
-
-// helper function to read stdin and send it over provided channel
-// https://stackoverflow.com/questions/50788805/how-to-read-from-stdin-with-goroutines-in-golang
-func readInputChannel(ch chan<- string) {
-	var val string
-	var err error
-	for {
-		msg := ""
-		if inputPwd == true {
-			val = readPassword("")
-			// read password again to match it
-			if v := readPassword("repeat password: "); v != val {
-				msg = fmt.Sprintf("WARNING: password match failed, will discard it ...")
-			}
-		} else {
-			val, err = readInput()
-			if err != nil {
-				msg = fmt.Sprintf("WARNING: wrong input %v", err)
-			}
-		}
-		inputPwd = false
-		if msg != "" {
-			ch <- msg
-		} else {
-			ch <- val
-		}
-		time.Sleep(time.Duration(1) * time.Millisecond) // wait for new input
+	password := bytes.TrimSpace(bytePassword)
+	if len(password) != len(bytePassword) {
+		// copy trimmed password and wipe original buffer
+		password = append([]byte{}, password...)
+		vault.Wipe(bytePassword)
 	}
+	return password
 }
 
+// clipboardClear defines delay in seconds after which clipboard is cleared
+var clipboardClear int
+
+// clipboardPending keeps track of scheduled clipboard clears
+var clipboardPending sync.WaitGroup
+
 // helper function to copy content to clipboard
-func copy2clipboard(val, msg string) {
+func copy2clipboard(val, msg string) error {
+	if clipboard.Unsupported {
+		return errClipboard
+	}
 	if err := clipboard.WriteAll(val); err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("%w, %v", errClipboard, err)
 	}
 	if msg != "" {
 		fmt.Println(msg)
 	}
+	if clipboardClear > 0 {
+		clipboardPending.Add(1)
+		go func() {
+			defer clipboardPending.Done()
+			clearClipboard(val, time.Duration(clipboardClear)*time.Second)
+		}()
+	}
+	return nil
+}
+
+// helper function to wait for scheduled clipboard clears, it is used by
+// scripts and -pwd option which otherwise exit before clipboard is cleared,
+// sensitive buffers are wiped before waiting
+func waitClipboard() {
+	wipeSecrets()
+	clipboardPending.Wait()
+}
+
+// helper function to clear clipboard after given delay if it still holds
+// given value
+func clearClipboard(val string, delay time.Duration) {
+	time.Sleep(delay)
+	if cur, err := clipboard.ReadAll(); err == nil && cur == val {
+		clipboard.WriteAll("")
+	}
 }
 
 // helper function to copy to clipboard db record attribute
-func clipboardCopy(input string) {
-	// the input here is cp <ID> attribute
-	arr := strings.Split(input, " ")
-	if len(arr) < 2 {
-		log.Printf("WARNING: unable to parse command '%s'", input)
-		return
+func (d *kdb) clipboardCopy(key, attr string) error {
+	rid, entry, err := d.findRecord(key)
+	if err != nil {
+		return err
 	}
-	rid, err := strconv.Atoi(arr[1])
+	// protected values are unsealed only for the time of copy
+	val, err := d.vault.Reveal(entry, vault.FieldKey(entry, attr))
 	if err != nil {
-		log.Println("Unable to get record ID", err)
-		return
+		return fmt.Errorf("unable to read %s, %v", attr, err)
 	}
-	attr := "password"
-	if len(arr) == 3 {
-		attr = strings.ToLower(arr[2])
+	defer vault.Wipe(val)
+	if len(val) == 0 {
+		return fmt.Errorf("%w: record %s has no %s attribute", errNotFound, key, attr)
 	}
-	var val string
-	if entry, ok := dbRecords[rid]; ok {
-		if attr == "password" {
-			val = entry.GetPassword()
-		} else if attr == "title" {
-			val = getValue(entry, "Title")
-		} else if attr == "username" {
-			val = getValue(entry, "UserName")
-		} else if attr == "login" {
-			val = getValue(entry, "Login")
-		} else if attr == "email" {
-			val = getValue(entry, "EMail")
-		} else if attr == "url" {
-			val = getValue(entry, "URL")
-		} else if attr == "notes" {
-			val = getValue(entry, "Notes")
-		}
-		if val != "" {
-			msg := fmt.Sprintf("%s copied to clipboard", attr)
-			copy2clipboard(string(val), msg)
+	if err := copy2clipboard(string(val), ""); err != nil {
+		return err
+	}
+	details := d.hookDetails()
+	details["RECORD"] = d.label(rid)
+	details["TITLE"] = entry.GetTitle()
+	details["FIELD"] = vault.FieldKey(entry, attr)
+	notifyHooks(hookOnCopy, details)
+	d.touch(rid)
+	return nil
+}
+
+// helper function to check if given list contains given value
+func inList(val string, list []string) bool {
+	for _, v := range list {
+		if v == val {
+			return true
 		}
 	}
+	return false
 }
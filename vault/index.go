@@ -0,0 +1,419 @@
+package vault
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"math/bits"
+	"sort"
+
+	gokeepasslib "github.com/tobischo/gokeepasslib/v3"
+)
+
+// index represents in-memory search index of database records, it is built
+// when database is opened, spliced when records are added or deleted and
+// updated in place when a record is modified. It holds searchable (non-protected) fields of every record,
+// character indexes used to select candidates of
+// fuzzy matching and trigram inverted index used to select candidates of
+// literal matching. Records are indexed by their IDs which are sequential.
+type index struct {
+	rids     []int                // sorted IDs of all records
+	entries  []gokeepasslib.Entry // records
+	fields   [][]field            // searchable fields of records
+	chars    *charIndex           // character index of plain word fields
+	allChars *charIndex           // character index of all fields
+	trigrams map[uint32][]int     // IDs of records which contain given trigram
+}
+
+// alphabet defines number of character classes of character index, i.e.
+// letters, digits and all other characters
+const alphabet = 37
+
+// charIndex represents bitsets of records which contain given character
+// class or given pair of character classes in the same order, e.g. record
+// with "gmail" text has (g,m), (g,l) and (m,l) pairs. Characters of fuzzy
+// matched word should appear in the same order, therefore every pair of
+// adjacent word characters selects candidates of fuzzy matching.
+type charIndex struct {
+	size  int                           // number of records
+	chars [alphabet][]uint64            // records with given character
+	pairs [alphabet * alphabet][]uint64 // records with given ordered pair of characters
+}
+
+// helper function to return class of given lower-cased character
+func charClass(r rune) int {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return int(r - 'a')
+	case r >= '0' && r <= '9':
+		return int(26 + r - '0')
+	}
+	return alphabet - 1
+}
+
+// helper function to create character index of given number of records
+func newCharIndex(size int) *charIndex {
+	ci := &charIndex{size: size}
+	words := (size + 63) / 64
+	for i := range ci.chars {
+		ci.chars[i] = make([]uint64, words)
+	}
+	for i := range ci.pairs {
+		ci.pairs[i] = make([]uint64, words)
+	}
+	return ci
+}
+
+// helper function to add lower-cased field values of given record to the
+// index, pairs are collected within every value since fuzzy matching is
+// performed over single field
+func (ci *charIndex) add(rid int, values []string) {
+	word, bit := rid/64, uint64(1)<<uint(rid%64)
+	// seen holds classes of all characters, pred holds classes which appear
+	// before given class within the same value
+	var seen uint64
+	var pred [alphabet]uint64
+	for _, val := range values {
+		var before uint64
+		for _, r := range val {
+			c := charClass(r)
+			pred[c] |= before
+			before |= 1 << uint(c)
+		}
+		seen |= before
+	}
+	for ; seen != 0; seen &= seen - 1 {
+		c := bits.TrailingZeros64(seen)
+		ci.chars[c][word] |= bit
+		for set := pred[c]; set != 0; set &= set - 1 {
+			ci.pairs[bits.TrailingZeros64(set)*alphabet+c][word] |= bit
+		}
+	}
+}
+
+// helper function to remove record from the index
+func (ci *charIndex) remove(rid int) {
+	word, bit := rid/64, uint64(1)<<uint(rid%64)
+	for c := range ci.chars {
+		ci.chars[c][word] &^= bit
+	}
+	for p := range ci.pairs {
+		ci.pairs[p][word] &^= bit
+	}
+}
+
+// helper function to splice records of character index, bits of records
+// from given position are shifted by number of inserted minus removed
+// records, bits of removed records should be cleared beforehand
+func (ci *charIndex) splice(pos, removed, inserted, size int) {
+	words := (size + 63) / 64
+	shift := inserted - removed
+	for i := range ci.chars {
+		ci.chars[i] = spliceBits(ci.chars[i], pos, shift, words)
+	}
+	for i := range ci.pairs {
+		ci.pairs[i] = spliceBits(ci.pairs[i], pos, shift, words)
+	}
+	ci.size = size
+}
+
+// helper function to return bitset of given number of words where bits
+// before given position are kept and following bits are moved by shift
+func spliceBits(set []uint64, pos, shift, words int) []uint64 {
+	out := make([]uint64, words)
+	n := pos / 64
+	copy(out, set[:n])
+	low := uint64(1)<<uint(pos%64) - 1
+	if low != 0 {
+		out[n] = set[n] & low
+	}
+	for w := n; w < len(set); w++ {
+		word := set[w]
+		if w == n {
+			word &^= low
+		}
+		if word == 0 {
+			continue
+		}
+		// bits are written at position of the word moved by shift, it is
+		// never negative for bits which are set
+		dst := w*64 + shift
+		if dst < 0 {
+			word >>= uint(-dst)
+			dst = 0
+		}
+		i, r := dst/64, uint(dst%64)
+		if i < words {
+			out[i] |= word << r
+		}
+		if r != 0 && i+1 < words {
+			out[i+1] |= word >> (64 - r)
+		}
+	}
+	return out
+}
+
+// helper function to return IDs of records which contain all characters of
+// given lower-cased word in the same order, i.e. every ordered pair of word
+// characters
+func (ci *charIndex) candidates(word string) []int {
+	var classes []int
+	for _, r := range word {
+		classes = append(classes, charClass(r))
+	}
+	var sets [][]uint64
+	if len(classes) == 1 {
+		sets = append(sets, ci.chars[classes[0]])
+	}
+	for i := 0; i < len(classes); i++ {
+		for j := i + 1; j < len(classes); j++ {
+			sets = append(sets, ci.pairs[classes[i]*alphabet+classes[j]])
+		}
+	}
+	rids := []int{}
+	for w := 0; w*64 < ci.size; w++ {
+		set := ^uint64(0)
+		for _, s := range sets {
+			if set &= s[w]; set == 0 {
+				break
+			}
+		}
+		for ; set != 0; set &= set - 1 {
+			rids = append(rids, w*64+bits.TrailingZeros64(set))
+		}
+	}
+	return rids
+}
+
+// helper function to call given function for every trigram of given
+// lower-cased string, trigrams are packed into integers
+func eachTrigram(val string, fn func(uint32)) {
+	for i := 0; i+3 <= len(val); i++ {
+		fn(uint32(val[i])<<16 | uint32(val[i+1])<<8 | uint32(val[i+2]))
+	}
+}
+
+// helper function to build search index of vault records
+func (v *Vault) buildIndex() {
+	size := len(v.entries)
+	ix := &index{
+		rids:     make([]int, size),
+		entries:  make([]gokeepasslib.Entry, size),
+		fields:   make([][]field, size),
+		chars:    newCharIndex(size),
+		allChars: newCharIndex(size),
+		trigrams: make(map[uint32][]int),
+	}
+	for rid := 0; rid < size; rid++ {
+		ix.rids[rid] = rid
+		ix.add(rid, v.entries[rid], v.recordFields(rid, v.entries[rid]))
+	}
+	v.index = ix
+}
+
+// helper function to update indexed record of given ID, only postings of
+// the record are changed
+func (v *Vault) updateIndex(rid int) {
+	if v.index == nil || rid >= len(v.index.rids) {
+		v.buildIndex()
+		return
+	}
+	v.index.remove(rid)
+	v.index.add(rid, v.entries[rid], v.recordFields(rid, v.entries[rid]))
+}
+
+// helper function to splice records of the index when records are added or
+// deleted, records from given position which are removed are dropped, IDs
+// of following records are shifted and inserted records are indexed, it is
+// done in place of rebuilding the whole index
+func (v *Vault) spliceIndex(pos, removed, inserted int) {
+	ix := v.index
+	size := len(v.entries)
+	if ix == nil || len(ix.rids)-removed+inserted != size {
+		v.buildIndex()
+		return
+	}
+	for rid := pos; rid < pos+removed; rid++ {
+		ix.remove(rid)
+	}
+	shift := inserted - removed
+	for _, list := range ix.trigrams {
+		for i := sort.SearchInts(list, pos); i < len(list); i++ {
+			list[i] += shift
+		}
+	}
+	rids := make([]int, size)
+	for rid := range rids {
+		rids[rid] = rid
+	}
+	entries := make([]gokeepasslib.Entry, 0, size)
+	entries = append(entries, ix.entries[:pos]...)
+	entries = append(entries, make([]gokeepasslib.Entry, inserted)...)
+	ix.entries = append(entries, ix.entries[pos+removed:]...)
+	fields := make([][]field, 0, size)
+	fields = append(fields, ix.fields[:pos]...)
+	fields = append(fields, make([][]field, inserted)...)
+	ix.fields = append(fields, ix.fields[pos+removed:]...)
+	ix.rids = rids
+	ix.chars.splice(pos, removed, inserted, size)
+	ix.allChars.splice(pos, removed, inserted, size)
+	for rid := pos; rid < pos+inserted; rid++ {
+		ix.add(rid, v.entries[rid], v.recordFields(rid, v.entries[rid]))
+	}
+}
+
+// helper function to add record with given ID and searchable fields to the
+// index, posting lists are kept sorted
+func (ix *index) add(rid int, entry gokeepasslib.Entry, fields []field) {
+	ix.entries[rid], ix.fields[rid] = entry, fields
+	var values, allValues []string
+	for _, f := range fields {
+		if f.weight > 0 {
+			allValues = append(allValues, f.lower)
+			if !f.extra {
+				values = append(values, f.lower)
+			}
+		}
+		eachTrigram(f.lower, func(tri uint32) {
+			list := ix.trigrams[tri]
+			// records are usually added in order of their IDs
+			if n := len(list); n == 0 || list[n-1] < rid {
+				ix.trigrams[tri] = append(list, rid)
+				return
+			}
+			i := sort.SearchInts(list, rid)
+			if list[i] != rid {
+				list = append(list, 0)
+				copy(list[i+1:], list[i:])
+				list[i] = rid
+				ix.trigrams[tri] = list
+			}
+		})
+	}
+	ix.chars.add(rid, values)
+	ix.allChars.add(rid, allValues)
+}
+
+// helper function to remove record of given ID from the index
+func (ix *index) remove(rid int) {
+	for _, f := range ix.fields[rid] {
+		eachTrigram(f.lower, func(tri uint32) {
+			list := ix.trigrams[tri]
+			i := sort.SearchInts(list, rid)
+			if i == len(list) || list[i] != rid {
+				return
+			}
+			if len(list) == 1 {
+				delete(ix.trigrams, tri)
+				return
+			}
+			ix.trigrams[tri] = append(list[:i], list[i+1:]...)
+		})
+	}
+	ix.fields[rid] = nil
+	ix.chars.remove(rid)
+	ix.allChars.remove(rid)
+}
+
+// helper function to return sorted IDs of candidate records which may match
+// given query node, nil means that every record is a candidate
+func (ix *index) candidates(n node, opts QueryOptions) []int {
+	switch q := n.(type) {
+	case fuzzyQuery:
+		// candidates contain all characters of the word in the same order
+		if opts.AllFields {
+			return ix.allChars.candidates(string(q))
+		}
+		return ix.chars.candidates(string(q))
+	case valueQuery:
+		if len(q.literal) < 3 {
+			return nil
+		}
+		// candidates contain all trigrams of the literal, posting lists are
+		// intersected starting from the shortest one
+		var lists [][]int
+		eachTrigram(q.literal, func(tri uint32) {
+			lists = append(lists, ix.trigrams[tri])
+		})
+		sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+		rids := append([]int{}, lists[0]...)
+		for _, list := range lists[1:] {
+			if len(rids) <= minCandidates {
+				break
+			}
+			rids = intersect(rids, list)
+		}
+		return rids
+	case andQuery:
+		var rids []int
+		for _, sub := range q {
+			cands := ix.candidates(sub, opts)
+			if cands == nil {
+				continue
+			}
+			if rids == nil {
+				rids = cands
+			} else {
+				rids = intersect(rids, cands)
+			}
+		}
+		return rids
+	case orQuery:
+		rids := []int{}
+		for _, sub := range q {
+			cands := ix.candidates(sub, opts)
+			if cands == nil {
+				return nil
+			}
+			rids = union(rids, cands)
+		}
+		return rids
+	}
+	return nil
+}
+
+// minCandidates defines number of candidate records which are verified
+// directly instead of further intersection of posting lists
+const minCandidates = 32
+
+// helper function to intersect sorted lists of record IDs
+func intersect(a, b []int) []int {
+	out := []int{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// helper function to merge sorted lists of record IDs
+func union(a, b []int) []int {
+	out := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			out = append(out, a[i])
+			i++
+		case a[i] > b[j]:
+			out = append(out, b[j])
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	out = append(out, a[i:]...)
+	return append(out, b[j:]...)
+}
@@ -0,0 +1,33 @@
+package vault
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import "unsafe"
+
+// Wipe zeroes given byte slice
+func Wipe(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+}
+
+// SecretString returns string which shares memory with given byte slice, it
+// allows to pass secrets via Record values without copying them into strings
+// which can't be wiped. The string is changed once the slice is wiped.
+func SecretString(buf []byte) string {
+	return byteString(buf)
+}
+
+// helper function to return string which shares memory with given byte
+// slice, it is used to match sensitive values without copying them into
+// strings which can't be wiped. The string is changed once the slice is
+// wiped, therefore it should not be used afterwards.
+func byteString(buf []byte) string {
+	if len(buf) == 0 {
+		return ""
+	}
+	return *(*string)(unsafe.Pointer(&buf))
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package vault
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// LockMemory locks given buffer in memory to prevent it from swapping, the
+// vault locks its own buffers on best effort basis and ignores failures which
+// are expected to be reported by callers locking their buffers
+func LockMemory(buf []byte) error {
+	if len(buf) == 0 {
+		return nil
+	}
+	if err := unix.Mlock(buf); err != nil {
+		return fmt.Errorf("unable to lock memory, %v", err)
+	}
+	return nil
+}
+
+// UnlockMemory unlocks given buffer
+func UnlockMemory(buf []byte) {
+	if len(buf) == 0 {
+		return
+	}
+	unix.Munlock(buf)
+}
+
+// DisableCoreDumps disables core dumps of kpass process, both protections
+// are applied even if one of them fails and the first failure is returned
+func DisableCoreDumps() error {
+	var errs []error
+	if err := unix.Prctl(unix.PR_SET_DUMPABLE, 0, 0, 0, 0); err != nil {
+		errs = append(errs, fmt.Errorf("unable to set process non-dumpable, %v", err))
+	}
+	rlim := unix.Rlimit{Cur: 0, Max: 0}
+	if err := unix.Setrlimit(unix.RLIMIT_CORE, &rlim); err != nil {
+		errs = append(errs, fmt.Errorf("unable to disable core dumps, %v", err))
+	}
+	if len(errs) != 0 {
+		return errs[0]
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package vault
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+// LockMemory locks given buffer in memory, no-op on this platform
+func LockMemory(buf []byte) error { return nil }
+
+// UnlockMemory unlocks given buffer, no-op on this platform
+func UnlockMemory(buf []byte) {}
+
+// DisableCoreDumps disables core dumps, no-op on this platform
+func DisableCoreDumps() error { return nil }
@@ -0,0 +1,561 @@
+package vault
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tobischo/gokeepasslib/v3/wrappers"
+)
+
+// ErrQuery is returned when search query can't be parsed
+var ErrQuery = errors.New("invalid query")
+
+// search modes define how plain words of the query are matched
+const (
+	ModeLiteral = "literal" // value contains the word
+	ModeFuzzy   = "fuzzy"   // characters appear in the same order, e.g. gml matches GMail
+	ModeRegex   = "regex"   // word is regular expression
+	ModeGlob    = "glob"    // word is glob pattern, e.g. git*
+)
+
+// Modes lists supported search modes
+var Modes = []string{ModeLiteral, ModeFuzzy, ModeRegex, ModeGlob}
+
+// QueryOptions represents options of query parsing
+type QueryOptions struct {
+	Mode             string `json:"mode,omitempty"`              // search mode of plain words, literal if empty
+	CaseSensitive    bool   `json:"case_sensitive,omitempty"`    // use case-sensitive matching, fuzzy mode ignores it
+	AllFields        bool   `json:"all_fields,omitempty"`        // match plain words over all fields, attachment names and history
+	IncludeProtected bool   `json:"include_protected,omitempty"` // match protected values as well
+}
+
+// CheckMode checks if given search mode is supported
+func CheckMode(mode string) error {
+	if mode == "" {
+		return nil
+	}
+	for _, m := range Modes {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w, unsupported search mode '%s', supported modes: %s",
+		ErrQuery, mode, strings.Join(Modes, ","))
+}
+
+// fieldAliases maps query field names to record keys
+var fieldAliases = map[string]string{
+	"title":    "Title",
+	"url":      "URL",
+	"user":     "UserName",
+	"username": "UserName",
+	"login":    "Login",
+	"email":    "Email",
+	"notes":    "Notes",
+	"tag":      "Tags",
+	"tags":     "Tags",
+	"group":    "Group",
+}
+
+// timeFields lists query fields which refer to record times
+var timeFields = []string{"created", "modified", "accessed", "expires"}
+
+// Query represents parsed search query
+type Query struct {
+	root node         // root node of query tree
+	opts QueryOptions // query options
+}
+
+// node represents node of query tree
+type node interface {
+	// eval returns score of given record and whether it matches the node,
+	// matched fields are added to the record
+	eval(r *record) (int, bool)
+}
+
+// andQuery matches records which match all its queries
+type andQuery []node
+
+func (q andQuery) eval(r *record) (int, bool) {
+	total, nhits := 0, len(r.hits)
+	for _, sub := range q {
+		score, ok := sub.eval(r)
+		if !ok {
+			r.hits = r.hits[:nhits]
+			return 0, false
+		}
+		total += score
+	}
+	return total, true
+}
+
+// orQuery matches records which match any of its queries
+type orQuery []node
+
+func (q orQuery) eval(r *record) (int, bool) {
+	total, matched := 0, false
+	for _, sub := range q {
+		if score, ok := sub.eval(r); ok {
+			total += score
+			matched = true
+		}
+	}
+	return total, matched
+}
+
+// notQuery matches records which do not match its query
+type notQuery struct {
+	query node
+}
+
+func (q notQuery) eval(r *record) (int, bool) {
+	nhits := len(r.hits)
+	_, ok := q.query.eval(r)
+	r.hits = r.hits[:nhits]
+	return 0, !ok
+}
+
+// fuzzyQuery performs fuzzy match of lower-cased word over searchable fields
+type fuzzyQuery string
+
+func (q fuzzyQuery) eval(r *record) (int, bool) {
+	score := r.fuzzy(string(q))
+	return score, score >= 0
+}
+
+// valueQuery matches field values (any searchable field if key is empty)
+// with given matcher
+type valueQuery struct {
+	key     string                  // record key
+	match   func(val string) []Span // value matcher, returns nil if value does not match
+	literal string                  // lower-cased literal pattern, used by search index
+	fold    bool                    // literal pattern is matched case-insensitively
+}
+
+// helper function to return spans of lower-cased literal pattern in given
+// field, it is faster equivalent of case-insensitive matcher which can be
+// used if lower-cased value has the same byte offsets as original one
+func (q valueQuery) literalSpans(f field) []Span {
+	var spans []Span
+	for pos := 0; pos <= len(f.lower); {
+		idx := strings.Index(f.lower[pos:], q.literal)
+		if idx < 0 {
+			break
+		}
+		start := pos + idx
+		spans = append(spans, Span{Start: start, End: start + len(q.literal)})
+		pos = start + len(q.literal)
+	}
+	return spans
+}
+
+func (q valueQuery) eval(r *record) (int, bool) {
+	fields := r.fields
+	if q.key != "" {
+		fields = r.values(q.key)
+	}
+	score := 0
+	for _, f := range fields {
+		if q.key == "" && !r.searched(f) {
+			continue
+		}
+		if q.literal != "" && !strings.Contains(f.lower, q.literal) {
+			// cheap check before matcher is used to get matched spans
+			continue
+		}
+		var spans []Span
+		if q.fold && q.literal != "" && len(f.lower) == len(f.value) {
+			spans = q.literalSpans(f)
+		} else {
+			spans = q.match(f.value)
+		}
+		if spans != nil {
+			r.hit(f, spans)
+			score++
+		}
+	}
+	return score, score > 0
+}
+
+// timeQuery compares record time with given one
+type timeQuery struct {
+	field string    // one of timeFields
+	op    string    // comparison operator
+	value time.Time // time to compare with
+	day   bool      // compare dates only
+}
+
+func (q timeQuery) eval(r *record) (int, bool) {
+	entry := r.entry
+	var t *wrappers.TimeWrapper
+	switch q.field {
+	case "created":
+		t = entry.Times.CreationTime
+	case "modified":
+		t = entry.Times.LastModificationTime
+	case "accessed":
+		t = entry.Times.LastAccessTime
+	case "expires":
+		if !entry.Times.Expires.Bool {
+			return 0, false
+		}
+		t = entry.Times.ExpiryTime
+	}
+	if t == nil {
+		return 0, false
+	}
+	val, ref := t.Time, q.value
+	if q.day {
+		val = time.Date(val.Year(), val.Month(), val.Day(), 0, 0, 0, 0, time.UTC)
+		ref = time.Date(ref.Year(), ref.Month(), ref.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	var ok bool
+	switch q.op {
+	case "<":
+		ok = val.Before(ref)
+	case "<=":
+		ok = !val.After(ref)
+	case ">":
+		ok = val.After(ref)
+	case ">=":
+		ok = !val.Before(ref)
+	default:
+		ok = val.Equal(ref)
+	}
+	return 0, ok
+}
+
+// helper function to split query into tokens, i.e. parentheses, words,
+// quoted phrases and /regex/ expressions, latter two can follow field name
+func lexQuery(query string) ([]string, error) {
+	var tokens []string
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		if r == ' ' || r == '\t' {
+			i++
+			continue
+		}
+		if r == '(' || r == ')' {
+			tokens = append(tokens, string(r))
+			i++
+			continue
+		}
+		start := i
+		for i < len(runes) && runes[i] != ' ' && runes[i] != '\t' && runes[i] != '(' && runes[i] != ')' {
+			c := runes[i]
+			prev := rune(0)
+			if i > start {
+				prev = runes[i-1]
+			}
+			if c == '"' || (c == '/' && (i == start || prev == ':')) {
+				// read quoted phrase or regex up to closing character
+				end := i + 1
+				for end < len(runes) && runes[end] != c {
+					if runes[end] == '\\' && c == '/' {
+						end++
+					}
+					end++
+				}
+				if end >= len(runes) {
+					return nil, fmt.Errorf("%w, unterminated %c in '%s'", ErrQuery, c, query)
+				}
+				i = end
+			}
+			i++
+		}
+		tokens = append(tokens, string(runes[start:i]))
+	}
+	return tokens, nil
+}
+
+// queryParser implements recursive descent parser of search query
+type queryParser struct {
+	tokens []string
+	pos    int
+	now    time.Time
+	opts   QueryOptions
+}
+
+// helper function to return next token
+func (p *queryParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// parseOr parses expression := and ('OR' and)*
+func (p *queryParser) parseOr() (node, error) {
+	var queries orQuery
+	for {
+		q, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+		if p.peek() != "OR" {
+			break
+		}
+		p.pos++
+	}
+	if len(queries) == 1 {
+		return queries[0], nil
+	}
+	return queries, nil
+}
+
+// parseAnd parses and := unary (['AND'] unary)*
+func (p *queryParser) parseAnd() (node, error) {
+	var queries andQuery
+	for {
+		q, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+		next := p.peek()
+		if next == "AND" {
+			p.pos++
+			continue
+		}
+		if next == "" || next == "OR" || next == ")" {
+			break
+		}
+	}
+	if len(queries) == 1 {
+		return queries[0], nil
+	}
+	return queries, nil
+}
+
+// parseUnary parses unary := 'NOT' unary | '(' expression ')' | term
+func (p *queryParser) parseUnary() (node, error) {
+	token := p.peek()
+	p.pos++
+	switch token {
+	case "":
+		return nil, fmt.Errorf("%w, unexpected end of query", ErrQuery)
+	case "NOT":
+		q, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notQuery{query: q}, nil
+	case "(":
+		q, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("%w, missing closing parenthesis", ErrQuery)
+		}
+		p.pos++
+		return q, nil
+	case ")", "AND", "OR":
+		return nil, fmt.Errorf("%w, unexpected '%s'", ErrQuery, token)
+	}
+	return p.parseTerm(token)
+}
+
+// parseTerm parses single query term, i.e. word, "phrase", /regex/ or
+// field:value where value can be any of them
+func (p *queryParser) parseTerm(token string) (node, error) {
+	field, value := "", token
+	if idx := strings.Index(token, ":"); idx > 0 && !strings.HasPrefix(token, "\"") &&
+		!strings.HasPrefix(token, "/") && !strings.HasPrefix(token[idx+1:], "//") {
+		field, value = strings.ToLower(token[:idx]), token[idx+1:]
+	}
+	for _, f := range timeFields {
+		if f == field {
+			return p.parseTime(field, value)
+		}
+	}
+	key := ""
+	if field != "" {
+		var ok bool
+		if key, ok = fieldAliases[field]; !ok {
+			// custom fields are referred by their exact names
+			key = token[:strings.Index(token, ":")]
+		}
+	}
+	mode := p.opts.Mode
+	if mode == "" {
+		mode = ModeLiteral
+	}
+	switch {
+	case len(value) >= 2 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/"):
+		mode, value = ModeRegex, value[1:len(value)-1]
+	case len(value) >= 2 && strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\""):
+		mode, value = ModeLiteral, value[1:len(value)-1]
+		if value == "" && key != "" {
+			// field:"" matches records without given field
+			return valueQuery{key: key, match: func(val string) []Span {
+				if val == "" {
+					return []Span{}
+				}
+				return nil
+			}}, nil
+		}
+	case value == "":
+		return nil, fmt.Errorf("%w, missing value of '%s' field", ErrQuery, field)
+	case mode == ModeFuzzy:
+		if key == "" {
+			return fuzzyQuery(strings.ToLower(value)), nil
+		}
+		// field values are matched literally
+		mode = ModeLiteral
+	}
+	match, err := p.matcher(mode, value)
+	if err != nil {
+		return nil, err
+	}
+	q := valueQuery{key: key, match: match}
+	if mode == ModeLiteral {
+		q.literal, q.fold = strings.ToLower(value), !p.opts.CaseSensitive
+	}
+	return q, nil
+}
+
+// helper function to return value matcher of given mode and pattern
+func (p *queryParser) matcher(mode, pattern string) (func(string) []Span, error) {
+	switch mode {
+	case ModeLiteral:
+		// literal pattern is matched as quoted regular expression to get
+		// case-insensitive matches with correct offsets
+		pattern = regexp.QuoteMeta(pattern)
+	case ModeGlob:
+		pattern = globRegexp(pattern)
+	}
+	if !p.opts.CaseSensitive {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %v", ErrQuery, err)
+	}
+	return func(val string) []Span {
+		var spans []Span
+		for _, loc := range re.FindAllStringIndex(val, -1) {
+			spans = append(spans, Span{Start: loc[0], End: loc[1]})
+		}
+		return spans
+	}, nil
+}
+
+// helper function to convert glob pattern into anchored regular expression,
+// glob supports * (any characters), ? (single character) and [...] classes
+func globRegexp(pattern string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		case '[':
+			end := i + 1
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if end == len(runes) {
+				sb.WriteString(regexp.QuoteMeta(string(r)))
+				continue
+			}
+			class := string(runes[i+1 : end])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+			i = end
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// parseTime parses time condition, e.g. modified:<2024-01-01 or
+// expires:<30d, relative durations (d, w, m, y) refer to the future for
+// expires field and to the past for other ones
+func (p *queryParser) parseTime(field, value string) (node, error) {
+	q := timeQuery{field: field, op: "="}
+	for _, op := range []string{"<=", ">=", "<", ">", "="} {
+		if strings.HasPrefix(value, op) {
+			q.op, value = op, value[len(op):]
+			break
+		}
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		q.value, q.day = t, true
+		return q, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		q.value = t
+		return q, nil
+	}
+	if len(value) > 1 {
+		num, err := strconv.Atoi(value[:len(value)-1])
+		if err == nil {
+			if field != "expires" {
+				num = -num
+			}
+			switch value[len(value)-1] {
+			case 'd':
+				q.value = p.now.AddDate(0, 0, num)
+				return q, nil
+			case 'w':
+				q.value = p.now.AddDate(0, 0, 7*num)
+				return q, nil
+			case 'm':
+				q.value = p.now.AddDate(0, num, 0)
+				return q, nil
+			case 'y':
+				q.value = p.now.AddDate(num, 0, 0)
+				return q, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w, wrong %s time '%s', use YYYY-MM-DD, RFC3339 or relative time, e.g. 30d", ErrQuery, field, value)
+}
+
+// ParseQuery parses search query. Query consists of words,
+// "quoted phrases", /regex/ and field:value terms, e.g. title:, url:, user:,
+// tag:, group: or custom field name, and time conditions, e.g.
+// modified:<2024-01-01 or expires:<30d. Terms are combined by AND (default),
+// OR and NOT operators and can be grouped by parentheses. Plain words are
+// matched according to search mode of given options, while "quoted phrases"
+// are always matched literally.
+func ParseQuery(query string, opts QueryOptions) (*Query, error) {
+	if err := CheckMode(opts.Mode); err != nil {
+		return nil, err
+	}
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%w, empty query", ErrQuery)
+	}
+	p := &queryParser{tokens: tokens, now: time.Now(), opts: opts}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(tokens) {
+		return nil, fmt.Errorf("%w, unexpected '%s'", ErrQuery, p.peek())
+	}
+	return &Query{root: root, opts: opts}, nil
+}
@@ -0,0 +1,147 @@
+package vault
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	gokeepasslib "github.com/tobischo/gokeepasslib/v3"
+	wrappers "github.com/tobischo/gokeepasslib/v3/wrappers"
+)
+
+// testRecord represents record of test vault
+type testRecord struct {
+	values   map[string]string
+	tags     string
+	created  time.Time
+	modified time.Time
+	expires  time.Time
+}
+
+// helper function to create vault with given records in Root group
+func testVault(t *testing.T, recs []testRecord) *Vault {
+	t.Helper()
+	root := gokeepasslib.NewGroup()
+	root.Name = "Root"
+	for _, rec := range recs {
+		entry := gokeepasslib.NewEntry()
+		keys := make([]string, 0, len(rec.values))
+		for key := range rec.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			entry.Values = append(entry.Values, mkValue(key, rec.values[key]))
+		}
+		entry.Tags = rec.tags
+		if !rec.created.IsZero() {
+			entry.Times.CreationTime = &wrappers.TimeWrapper{Time: rec.created}
+		}
+		if !rec.modified.IsZero() {
+			entry.Times.LastModificationTime = &wrappers.TimeWrapper{Time: rec.modified}
+		}
+		if !rec.expires.IsZero() {
+			entry.Times.Expires = wrappers.NewBoolWrapper(true)
+			entry.Times.ExpiryTime = &wrappers.TimeWrapper{Time: rec.expires}
+		}
+		root.Entries = append(root.Entries, entry)
+	}
+	v := &Vault{db: &gokeepasslib.Database{Content: &gokeepasslib.DBContent{
+		Root: &gokeepasslib.RootData{Groups: []gokeepasslib.Group{root}}}}}
+	if err := v.read(); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// helper function to return date of given year, month and day
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 12, 0, 0, 0, time.UTC)
+}
+
+// records used to test query language
+var queryRecords = []testRecord{
+	{values: map[string]string{"Title": "GMail", "UserName": "alice@gmail.com",
+		"URL": "https://mail.google.com", "Env": "prod"}, tags: "mail",
+		created: date(2023, 1, 10), modified: date(2024, 3, 1)},
+	{values: map[string]string{"Title": "GitHub", "UserName": "alice",
+		"URL": "https://github.com/login", "Notes": "ssh key in vault"}, tags: "dev",
+		created: date(2023, 5, 1), modified: date(2024, 6, 15)},
+	{values: map[string]string{"Title": "Bank", "UserName": "alice", "Notes": "pin"},
+		expires: time.Now().AddDate(0, 0, 10)},
+	{values: map[string]string{"Title": "db prod", "Env": "staging"}},
+}
+
+func TestQuery(t *testing.T) {
+	v := testVault(t, queryRecords)
+	tests := []struct {
+		query string
+		opts  QueryOptions
+		want  []int
+	}{
+		{query: "alice", want: []int{0, 1, 2}},
+		{query: "alice github", want: []int{1}},
+		{query: "alice AND github", want: []int{1}},
+		{query: "gmail OR bank", want: []int{0, 2}},
+		{query: "NOT alice", want: []int{3}},
+		{query: "alice NOT (gmail OR bank)", want: []int{1}},
+		{query: "(gmail OR github) mail", want: []int{0}},
+		{query: "tag:dev", want: []int{1}},
+		{query: `title:"db prod"`, want: []int{3}},
+		{query: `"db prod" OR notes:pin`, want: []int{2, 3}},
+		{query: "Env:prod", want: []int{0}},
+		{query: `url:""`, want: []int{2, 3}},
+		{query: `NOT url:""`, want: []int{0, 1}},
+		{query: `Env:""`, want: []int{1, 2}},
+		{query: "/git(hub|lab)/", want: []int{1}},
+		{query: "title:/^g/", want: []int{0, 1}},
+		{query: "GMAIL", want: []int{0}},
+		{query: "GMAIL", opts: QueryOptions{CaseSensitive: true}, want: []int{}},
+		{query: "gmal", opts: QueryOptions{Mode: ModeFuzzy}, want: []int{0}},
+		{query: "git*", opts: QueryOptions{Mode: ModeGlob}, want: []int{1}},
+		{query: "vault", want: []int{1}},
+		{query: "staging", want: []int{}},
+		{query: "staging", opts: QueryOptions{AllFields: true}, want: []int{3}},
+		{query: "created:<2023-02-01", want: []int{0}},
+		{query: "modified:2024-06-15", want: []int{1}},
+		{query: "modified:<2024-06-01", want: []int{0}},
+		{query: "modified:>=2024-06-15", want: []int{1, 2, 3}},
+		{query: "modified:<7d", want: []int{0, 1}},
+		{query: "modified:>1w", want: []int{2, 3}},
+		{query: "expires:<30d", want: []int{2}},
+		{query: "expires:>30d", want: []int{}},
+		{query: "NOT expires:<1y", want: []int{0, 1, 3}},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.query, tt.opts)
+		if err != nil {
+			t.Errorf("query %q: %v", tt.query, err)
+			continue
+		}
+		got := matchIDs(v.Find(q))
+		sort.Ints(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("query %q with %+v: got %v, want %v", tt.query, tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	for _, query := range []string{"", "(alice", "alice)", "alice OR", "NOT",
+		"AND alice", `"alice`, "/alice", "title:", "modified:<yesterday",
+		"expires:30x", "/[a/"} {
+		if _, err := ParseQuery(query, QueryOptions{}); !errors.Is(err, ErrQuery) {
+			t.Errorf("query %q: got %v, want %v", query, err, ErrQuery)
+		}
+	}
+	if _, err := ParseQuery("alice", QueryOptions{Mode: "exact"}); err == nil {
+		t.Error("unsupported search mode is accepted")
+	}
+}
@@ -0,0 +1,128 @@
+package vault
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	gokeepasslib "github.com/tobischo/gokeepasslib/v3"
+)
+
+// savedSearchKey defines prefix of database custom data keys which hold
+// saved searches
+const savedSearchKey = "kpass.search."
+
+// SavedSearch represents named search query stored in the database, the
+// query is parsed every time it is run, i.e. relative times like 30d are
+// always relative to the current time
+type SavedSearch struct {
+	Name    string       `json:"-"`       // name of the search
+	Query   string       `json:"query"`   // search query
+	Options QueryOptions `json:"options"` // query options
+}
+
+// Parse parses query of saved search
+func (s SavedSearch) Parse() (*Query, error) {
+	return ParseQuery(s.Query, s.Options)
+}
+
+// helper function to check name of saved search, names are used as paths
+// of virtual groups and therefore can't contain slashes
+func checkSearchName(name string) error {
+	if strings.TrimSpace(name) == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid name of saved search '%s'", name)
+	}
+	return nil
+}
+
+// SavedSearches returns searches saved in the database sorted by their names
+func (v *Vault) SavedSearches() []SavedSearch {
+	var searches []SavedSearch
+	if v.db == nil || v.db.Content.Meta == nil {
+		return searches
+	}
+	for _, item := range v.db.Content.Meta.CustomData {
+		if !strings.HasPrefix(item.Key, savedSearchKey) {
+			continue
+		}
+		var s SavedSearch
+		if err := json.Unmarshal([]byte(item.Value), &s); err != nil {
+			// value is written by other program, use it as plain query
+			s.Query = item.Value
+		}
+		s.Name = strings.TrimPrefix(item.Key, savedSearchKey)
+		searches = append(searches, s)
+	}
+	sort.Slice(searches, func(i, j int) bool { return searches[i].Name < searches[j].Name })
+	return searches
+}
+
+// SavedSearch returns saved search of given name
+func (v *Vault) SavedSearch(name string) (SavedSearch, error) {
+	for _, s := range v.SavedSearches() {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return SavedSearch{}, fmt.Errorf("%w: no saved search '%s'", ErrNotFound, name)
+}
+
+// SaveSearch stores given search in the database custom data, existing
+// search of the same name is replaced. Changes are kept in memory until Save
+// is called.
+func (v *Vault) SaveSearch(s SavedSearch) error {
+	if v.db == nil {
+		return ErrLocked
+	}
+	if err := checkSearchName(s.Name); err != nil {
+		return err
+	}
+	if _, err := s.Parse(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if v.db.Content.Meta == nil {
+		v.db.Content.Meta = gokeepasslib.NewMetaData()
+	}
+	meta := v.db.Content.Meta
+	item := gokeepasslib.CustomData{Key: savedSearchKey + s.Name, Value: string(data)}
+	for i := range meta.CustomData {
+		if meta.CustomData[i].Key == item.Key {
+			meta.CustomData[i] = item
+			v.dirty = true
+			return nil
+		}
+	}
+	meta.CustomData = append(meta.CustomData, item)
+	v.dirty = true
+	return nil
+}
+
+// DeleteSearch removes saved search of given name from the database
+func (v *Vault) DeleteSearch(name string) error {
+	if v.db == nil {
+		return ErrLocked
+	}
+	if _, err := v.SavedSearch(name); err != nil {
+		return err
+	}
+	meta := v.db.Content.Meta
+	var items []gokeepasslib.CustomData
+	for _, item := range meta.CustomData {
+		if item.Key != savedSearchKey+name {
+			items = append(items, item)
+		}
+	}
+	meta.CustomData = items
+	v.dirty = true
+	return nil
+}
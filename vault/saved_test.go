@@ -0,0 +1,112 @@
+package vault
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	gokeepasslib "github.com/tobischo/gokeepasslib/v3"
+)
+
+// helper function to return names of given saved searches
+func searchNames(searches []SavedSearch) []string {
+	names := []string{}
+	for _, s := range searches {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestSavedSearches(t *testing.T) {
+	v := testVault(t, queryRecords)
+	v.db.Content.Meta = gokeepasslib.NewMetaData()
+	// value written by other program is used as plain query
+	v.db.Content.Meta.CustomData = []gokeepasslib.CustomData{
+		{Key: "other.setting", Value: "1"},
+		{Key: savedSearchKey + "plain", Value: "tag:dev"},
+	}
+	tests := []struct {
+		search SavedSearch
+		err    bool
+	}{
+		{search: SavedSearch{Name: "mail", Query: "gmail OR mail"}},
+		{search: SavedSearch{Name: "on call", Query: "gml", Options: QueryOptions{Mode: ModeFuzzy}}},
+		{search: SavedSearch{Name: "mail", Query: "alice NOT bank"}},
+		{search: SavedSearch{Name: "", Query: "alice"}, err: true},
+		{search: SavedSearch{Name: "a/b", Query: "alice"}, err: true},
+		{search: SavedSearch{Name: "broken", Query: "(alice"}, err: true},
+		{search: SavedSearch{Name: "mode", Query: "alice", Options: QueryOptions{Mode: "exact"}}, err: true},
+	}
+	for _, tt := range tests {
+		if err := v.SaveSearch(tt.search); (err != nil) != tt.err {
+			t.Errorf("save search %+v: got error %v", tt.search, err)
+		}
+	}
+	if !v.Dirty() {
+		t.Error("saved searches do not change the database")
+	}
+	if got, want := searchNames(v.SavedSearches()), []string{"mail", "on call", "plain"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("saved searches: got %v, want %v", got, want)
+	}
+	runs := []struct {
+		name string
+		want []int
+	}{
+		{"mail", []int{0, 1}},
+		{"on call", []int{0}},
+		{"plain", []int{1}},
+	}
+	for _, tt := range runs {
+		s, err := v.SavedSearch(tt.name)
+		if err != nil {
+			t.Errorf("saved search %q: %v", tt.name, err)
+			continue
+		}
+		q, err := s.Parse()
+		if err != nil {
+			t.Errorf("saved search %q: %v", tt.name, err)
+			continue
+		}
+		if got := matchIDs(v.Find(q)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("saved search %q: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if err := v.DeleteSearch("mail"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.DeleteSearch("mail"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("delete of missing search: got %v, want %v", err, ErrNotFound)
+	}
+	if _, err := v.SavedSearch("mail"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted search: got %v, want %v", err, ErrNotFound)
+	}
+	if n := len(v.db.Content.Meta.CustomData); n != 3 {
+		t.Errorf("custom data items: got %d, want 3", n)
+	}
+}
+
+func TestSavedSearchesSave(t *testing.T) {
+	v, err := Open(testDatabase(t, "Root"), "", []byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := SavedSearch{Name: "records", Query: "record", Options: QueryOptions{Mode: ModeFuzzy}}
+	if err := v.SaveSearch(search); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Open(v.SavePath(), "", []byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := saved.SavedSearches(); !reflect.DeepEqual(got, []SavedSearch{search}) {
+		t.Errorf("saved searches of written database: got %+v, want %+v", got, search)
+	}
+}
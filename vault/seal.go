@@ -0,0 +1,176 @@
+package vault
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	gokeepasslib "github.com/tobischo/gokeepasslib/v3"
+)
+
+// helper function to generate random session key used to seal protected
+// values of the vault, the key is wiped when vault is locked
+func (v *Vault) newSessionKey() error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	LockMemory(key)
+	v.key = key
+	return nil
+}
+
+// helper function to return AEAD cipher based on session key
+func (v *Vault) sessionCipher() (cipher.AEAD, error) {
+	if v.key == nil {
+		return nil, ErrLocked
+	}
+	block, err := aes.NewCipher(v.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// helper function to seal given value with session key
+func (v *Vault) seal(val []byte) (string, error) {
+	aead, err := v.sessionCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	data := aead.Seal(nonce, nonce, val, nil)
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// helper function to unseal given value with session key, the returned
+// byte slice should be wiped by the caller once it is no longer needed
+func (v *Vault) unseal(val string) ([]byte, error) {
+	aead, err := v.sessionCipher()
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(val)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("malformed sealed value")
+	}
+	nonce, data := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, data, nil)
+}
+
+// IsProtected reports if given value is protected
+func IsProtected(val gokeepasslib.ValueData) bool {
+	return val.Value.Protected.Bool
+}
+
+// helper function to seal all protected values of given entries in place
+func (v *Vault) sealEntries(entries []gokeepasslib.Entry) error {
+	for i := range entries {
+		for j := range entries[i].Values {
+			if !IsProtected(entries[i].Values[j]) {
+				continue
+			}
+			sealed, err := v.seal([]byte(entries[i].Values[j].Value.Content))
+			if err != nil {
+				return err
+			}
+			entries[i].Values[j].Value.Content = sealed
+		}
+		for j := range entries[i].Histories {
+			if err := v.sealEntries(entries[i].Histories[j].Entries); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// helper function to seal all protected values of given groups in place
+func (v *Vault) sealGroups(groups []gokeepasslib.Group) error {
+	for i := range groups {
+		if err := v.sealEntries(groups[i].Entries); err != nil {
+			return err
+		}
+		if err := v.sealGroups(groups[i].Groups); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function to return copy of given entries with unsealed protected
+// values, it is used right before database is encoded
+func (v *Vault) unsealEntries(entries []gokeepasslib.Entry) ([]gokeepasslib.Entry, error) {
+	var out []gokeepasslib.Entry
+	for _, entry := range entries {
+		values := make([]gokeepasslib.ValueData, len(entry.Values))
+		copy(values, entry.Values)
+		for i := range values {
+			if !IsProtected(values[i]) {
+				continue
+			}
+			val, err := v.unseal(values[i].Value.Content)
+			if err != nil {
+				return nil, fmt.Errorf("unable to unseal %s value, %v", values[i].Key, err)
+			}
+			values[i].Value.Content = string(val)
+			Wipe(val)
+		}
+		entry.Values = values
+		histories := make([]gokeepasslib.History, len(entry.Histories))
+		for i, hist := range entry.Histories {
+			hentries, err := v.unsealEntries(hist.Entries)
+			if err != nil {
+				return nil, err
+			}
+			histories[i] = gokeepasslib.History{Entries: hentries}
+		}
+		entry.Histories = histories
+		out = append(out, entry)
+	}
+	return out, nil
+}
+
+// helper function to return copy of given groups and their sub-groups with
+// unsealed protected values
+func (v *Vault) unsealGroups(groups []gokeepasslib.Group) ([]gokeepasslib.Group, error) {
+	var out []gokeepasslib.Group
+	for _, group := range groups {
+		var err error
+		if group.Entries, err = v.unsealEntries(group.Entries); err != nil {
+			return nil, err
+		}
+		if group.Groups, err = v.unsealGroups(group.Groups); err != nil {
+			return nil, err
+		}
+		out = append(out, group)
+	}
+	return out, nil
+}
+
+// Reveal returns value of given entry key, protected values are unsealed
+// and the returned byte slice should be wiped by the caller
+func (v *Vault) Reveal(entry gokeepasslib.Entry, key string) ([]byte, error) {
+	ptr := entry.Get(key)
+	if ptr == nil {
+		return nil, nil
+	}
+	if IsProtected(*ptr) {
+		return v.unseal(ptr.Value.Content)
+	}
+	return []byte(ptr.Value.Content), nil
+}
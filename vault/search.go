@@ -0,0 +1,476 @@
+package vault
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bytes"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	gokeepasslib "github.com/tobischo/gokeepasslib/v3"
+)
+
+// Match represents search result, i.e. record ID, its relevance score and
+// matched fields
+type Match struct {
+	ID     int          // record ID
+	Score  int          // relevance score, higher is better
+	Fields []FieldMatch // matched fields
+}
+
+// Span represents matched part of field value as byte offsets
+type Span struct {
+	Start int // offset of first matched byte
+	End   int // offset after last matched byte
+}
+
+// FieldMatch represents matched record field, value and spans are empty for
+// protected fields to avoid their exposure
+type FieldMatch struct {
+	Key       string // field key, e.g. Title, Attachment or History/URL
+	Value     string // matched value
+	Protected bool   // field is protected
+	Spans     []Span // matched parts of the value
+}
+
+// searchField represents record field used in search along with its weight
+type searchField struct {
+	key    string // entry key
+	weight int    // weight of field score
+}
+
+// searchFields defines record fields used in search, title matches are
+// more relevant than URL host, username and tags ones
+var searchFields = []searchField{
+	{"Title", 4},
+	{"URL", 3},
+	{"UserName", 2},
+	{"Tags", 2},
+	{"Login", 1},
+	{"Email", 1},
+	{"Notes", 1},
+}
+
+// field represents searchable field of a record
+type field struct {
+	key       string // field key
+	value     string // field value
+	lower     string // lower-cased field value
+	host      string // host of URL field
+	hostAt    int    // offset of host in URL field
+	weight    int    // weight of field score, 0 if field is searched by name only
+	extra     bool   // field is searched by plain words only with AllFields option
+	protected bool   // field value is protected
+}
+
+// record represents record being matched by query along with its
+// searchable fields and matched parts of them
+type record struct {
+	fields    []field
+	entry     *gokeepasslib.Entry
+	allFields bool
+	hits      []FieldMatch
+}
+
+// helper function to check if given field is searched by plain words
+func (r *record) searched(f field) bool {
+	return f.weight > 0 && (r.allFields || !f.extra)
+}
+
+// helper function to return values of given record field
+func (r *record) values(key string) []field {
+	var out []field
+	for _, f := range r.fields {
+		if f.key == key {
+			out = append(out, f)
+		}
+	}
+	if len(out) == 0 {
+		// missing field is matched as empty one
+		out = append(out, field{key: key})
+	}
+	return out
+}
+
+// helper function to add matched field
+func (r *record) hit(f field, spans []Span) {
+	fm := FieldMatch{Key: f.key, Protected: f.protected}
+	if !f.protected {
+		fm.Value, fm.Spans = f.value, spans
+	}
+	r.hits = append(r.hits, fm)
+}
+
+// scores used by fuzzy matching
+const (
+	scoreMatch       = 1  // every matched character
+	scoreConsecutive = 4  // character follows previous matched one
+	scoreWordStart   = 6  // character starts a word
+	scoreSubstring   = 10 // query is substring of the value
+	scoreExact       = 20 // query equals to the value
+	penaltyGap       = 2  // every skipped character between matches
+)
+
+// helper function to check if character at given position starts a word
+func wordStart(runes []rune, idx int) bool {
+	if idx == 0 {
+		return true
+	}
+	prev, cur := runes[idx-1], runes[idx]
+	if !unicode.IsLetter(prev) && !unicode.IsDigit(prev) {
+		return true
+	}
+	return unicode.IsLower(prev) && unicode.IsUpper(cur)
+}
+
+// FuzzyScore returns score of fuzzy match of query within given value, the
+// query characters should appear in the value in the same order (case is
+// ignored), e.g. gml matches GMail. It returns -1 if value does not match
+// or matched characters are scattered over the value.
+func FuzzyScore(query, value string) int {
+	score, _ := fuzzyMatch(query, value)
+	return score
+}
+
+// helper function to perform fuzzy match, it returns score and matched
+// spans of the value
+func fuzzyMatch(query, value string) (int, []Span) {
+	if query == "" {
+		return 0, nil
+	}
+	runes := []rune(value)
+	// byte offsets of runes
+	offsets := make([]int, len(runes)+1)
+	for i, r := range runes {
+		offsets[i+1] = offsets[i] + utf8.RuneLen(r)
+	}
+	var spans []Span
+	score, pos, last := 0, 0, -1
+	for _, r := range query {
+		r = unicode.ToLower(r)
+		found := -1
+		// prefer match at word start over the first occurrence
+		for i := pos; i < len(runes); i++ {
+			if unicode.ToLower(runes[i]) != r {
+				continue
+			}
+			if found < 0 {
+				found = i
+			}
+			if i == last+1 || wordStart(runes, i) {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			return -1, nil
+		}
+		score += scoreMatch
+		if found == last+1 && last >= 0 {
+			score += scoreConsecutive
+			spans[len(spans)-1].End = offsets[found+1]
+		} else {
+			spans = append(spans, Span{Start: offsets[found], End: offsets[found+1]})
+		}
+		if wordStart(runes, found) {
+			score += scoreWordStart
+		}
+		if last >= 0 {
+			score -= penaltyGap * (found - last - 1)
+		}
+		last = found
+		pos = found + 1
+	}
+	lvalue, lquery := strings.ToLower(value), strings.ToLower(query)
+	if lvalue == lquery {
+		score += scoreExact
+	} else if strings.Contains(lvalue, lquery) {
+		score += scoreSubstring
+	}
+	if score <= 0 {
+		// matched characters are too scattered over the value
+		return -1, nil
+	}
+	return score, spans
+}
+
+// helper function to return host of given URL, or URL itself if it can't
+// be parsed
+func urlHost(val string) string {
+	if u, err := url.Parse(val); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	return val
+}
+
+// helper function to check if characters of given lower-cased term appear
+// in lower-cased value in the same order
+func subsequence(term, value string) bool {
+	for _, r := range term {
+		idx := strings.IndexRune(value, r)
+		if idx < 0 {
+			return false
+		}
+		value = value[idx+utf8.RuneLen(r):]
+	}
+	return true
+}
+
+// helper function to return score of best matching record field for given
+// lower-cased query term, it returns -1 if record does not match the term
+func (r *record) fuzzy(term string) int {
+	best, bestField := -1, field{}
+	var bestSpans []Span
+	for _, f := range r.fields {
+		if !r.searched(f) || f.value == "" || !subsequence(term, f.lower) {
+			continue
+		}
+		val, offset := f.value, 0
+		if f.host != "" {
+			// URL is matched by its host
+			val, offset = f.host, f.hostAt
+		}
+		score, spans := fuzzyMatch(term, val)
+		if score >= 0 && score*f.weight > best {
+			best, bestField, bestSpans = score*f.weight, f, spans
+			for i := range bestSpans {
+				bestSpans[i].Start += offset
+				bestSpans[i].End += offset
+			}
+		}
+	}
+	if best >= 0 {
+		r.hit(bestField, bestSpans)
+	}
+	return best
+}
+
+// helper function to return weight of given record key
+func fieldWeight(key string) int {
+	for _, f := range searchFields {
+		if f.key == key {
+			return f.weight
+		}
+	}
+	return 1
+}
+
+// helper function to check if given key is one of searchFields
+func standardField(key string) bool {
+	for _, f := range searchFields {
+		if f.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to return non-protected searchable fields of given
+// record, i.e. group, tags, values, attachment names and history values
+// which differ from current ones. Fields outside of searchFields are marked
+// as extra ones.
+func (v *Vault) recordFields(rid int, entry gokeepasslib.Entry) []field {
+	fields := []field{{key: "Group", value: v.groups[rid]}}
+	if entry.Tags != "" {
+		fields = append(fields, field{key: "Tags", value: entry.Tags, weight: fieldWeight("Tags")})
+	}
+	current := make(map[string]string)
+	for _, val := range entry.Values {
+		current[val.Key] = val.Value.Content
+		if IsProtected(val) || val.Value.Content == "" {
+			continue
+		}
+		fields = append(fields, field{key: val.Key, value: val.Value.Content,
+			weight: fieldWeight(val.Key), extra: !standardField(val.Key)})
+	}
+	for _, bin := range entry.Binaries {
+		fields = append(fields, field{key: "Attachment", value: bin.Name, weight: 1, extra: true})
+	}
+	// history values are included only if they differ from current ones
+	seen := make(map[string]bool)
+	for _, history := range entry.Histories {
+		for _, old := range history.Entries {
+			for _, val := range old.Values {
+				id := val.Key + "\x00" + val.Value.Content
+				if IsProtected(val) || val.Value.Content == "" || current[val.Key] == val.Value.Content || seen[id] {
+					continue
+				}
+				seen[id] = true
+				fields = append(fields, field{key: "History/" + val.Key, value: val.Value.Content, weight: 1, extra: true})
+			}
+		}
+	}
+	for i := range fields {
+		fields[i].lower = strings.ToLower(fields[i].value)
+		if fields[i].key == "URL" {
+			host := urlHost(fields[i].value)
+			if idx := strings.Index(fields[i].value, host); idx >= 0 {
+				fields[i].host, fields[i].hostAt = host, idx
+			}
+		}
+	}
+	return fields
+}
+
+// helper function to return protected fields of given record with
+// unsealed values, they are used only if IncludeProtected option is set.
+// Field values refer to unsealed byte slices which are locked in memory,
+// the returned function wipes them and should be called once fields are
+// matched.
+func (v *Vault) protectedFields(entry gokeepasslib.Entry) ([]field, func()) {
+	var fields []field
+	var buffers [][]byte
+	wipe := func() {
+		for _, buf := range buffers {
+			Wipe(buf)
+			UnlockMemory(buf)
+		}
+	}
+	// helper function to check if given field value is already included
+	seen := func(key string, data []byte) bool {
+		for _, f := range fields {
+			if f.key == key && f.value == byteString(data) {
+				return true
+			}
+		}
+		return false
+	}
+	// helper function to add unsealed value which differs from given one
+	add := func(val gokeepasslib.ValueData, key string, weight int, current []byte) []byte {
+		data, err := v.unseal(val.Value.Content)
+		if err != nil || len(data) == 0 || bytes.Equal(data, current) || seen(key, data) {
+			Wipe(data)
+			return nil
+		}
+		lower := bytes.ToLower(data)
+		LockMemory(data)
+		LockMemory(lower)
+		buffers = append(buffers, data, lower)
+		fields = append(fields, field{key: key, value: byteString(data), lower: byteString(lower),
+			weight: weight, protected: true})
+		return data
+	}
+	current := make(map[string][]byte)
+	for _, val := range entry.Values {
+		if IsProtected(val) {
+			current[val.Key] = add(val, val.Key, fieldWeight(val.Key), nil)
+		}
+	}
+	// history values are included only if they differ from current ones
+	for _, history := range entry.Histories {
+		for _, old := range history.Entries {
+			for _, val := range old.Values {
+				if IsProtected(val) {
+					add(val, "History/"+val.Key, 1, current[val.Key])
+				}
+			}
+		}
+	}
+	return fields, wipe
+}
+
+// Search returns records matching given query, see ParseQuery for its
+// syntax. Plain words are matched literally over record title, URL host,
+// username, tags and other non-protected fields. Results are sorted by their
+// relevance and record ID.
+func (v *Vault) Search(query string) ([]Match, error) {
+	q, err := ParseQuery(query, QueryOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return v.Find(q), nil
+}
+
+// Find returns records matching given parsed query sorted by their
+// relevance and record ID
+func (v *Vault) Find(q *Query) []Match {
+	if v.index == nil {
+		return nil
+	}
+	rids := v.index.candidates(q.root, q.opts)
+	if rids == nil || q.opts.IncludeProtected {
+		// protected values are not indexed
+		rids = v.index.rids
+	}
+	return v.match(q, rids)
+}
+
+// helper function to return matches of given query among given records
+func (v *Vault) match(q *Query, rids []int) []Match {
+	var matches []Match
+	r := &record{allFields: q.opts.AllFields}
+	now := time.Now()
+	for _, rid := range rids {
+		r.entry, r.fields, r.hits = &v.index.entries[rid], v.index.fields[rid], r.hits[:0]
+		wipe := func() {}
+		if q.opts.IncludeProtected {
+			fields := make([]field, len(r.fields))
+			copy(fields, r.fields)
+			var protected []field
+			protected, wipe = v.protectedFields(*r.entry)
+			r.fields = append(fields, protected...)
+		}
+		score, ok := q.root.eval(r)
+		// matched protected fields keep neither values nor spans, therefore
+		// unsealed values are wiped right after the record is matched
+		wipe()
+		if ok {
+			// favorite and frequently used records are shown first
+			score += usageScore(r.entry, now)
+			matches = append(matches, Match{ID: rid, Score: score, Fields: mergeHits(r.hits)})
+		}
+	}
+	SortMatches(matches)
+	return matches
+}
+
+// helper function to merge matched fields with the same key and value
+func mergeHits(hits []FieldMatch) []FieldMatch {
+	var out []FieldMatch
+	for _, hit := range hits {
+		merged := false
+		for i := range out {
+			if out[i].Key == hit.Key && out[i].Value == hit.Value {
+				out[i].Spans = append(out[i].Spans, hit.Spans...)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			out = append(out, hit)
+		}
+	}
+	for i := range out {
+		spans := out[i].Spans
+		sort.Slice(spans, func(a, b int) bool { return spans[a].Start < spans[b].Start })
+		var norm []Span
+		for _, sp := range spans {
+			if n := len(norm); n > 0 && sp.Start <= norm[n-1].End {
+				if sp.End > norm[n-1].End {
+					norm[n-1].End = sp.End
+				}
+				continue
+			}
+			norm = append(norm, sp)
+		}
+		out[i].Spans = norm
+	}
+	return out
+}
+
+// SortMatches sorts matches by their score and record ID
+func SortMatches(matches []Match) {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+}
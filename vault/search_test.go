@@ -0,0 +1,223 @@
+package vault
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+
+	gokeepasslib "github.com/tobischo/gokeepasslib/v3"
+)
+
+// words used to generate synthetic records
+var benchWords = []string{"mail", "bank", "cloud", "server", "router", "forum",
+	"shop", "news", "wiki", "chat", "drive", "photo", "music", "video", "game",
+	"tax", "health", "travel", "school", "work"}
+
+// helper function to create vault with given number of synthetic records
+func benchVault(tb testing.TB, size int) *Vault {
+	rnd := rand.New(rand.NewSource(1))
+	value := func(key, val string) gokeepasslib.ValueData {
+		return gokeepasslib.ValueData{Key: key, Value: gokeepasslib.V{Content: val}}
+	}
+	root := gokeepasslib.NewGroup()
+	root.Name = "Root"
+	for i := 0; i < size; i++ {
+		w1 := benchWords[rnd.Intn(len(benchWords))]
+		w2 := benchWords[rnd.Intn(len(benchWords))]
+		entry := gokeepasslib.NewEntry()
+		entry.Values = []gokeepasslib.ValueData{
+			value("Title", fmt.Sprintf("%s %s %d", w1, w2, i)),
+			value("UserName", fmt.Sprintf("user%d@%s.example.com", i, w2)),
+			value("URL", fmt.Sprintf("https://%s%d.%s.example.com/login", w1, i, w2)),
+			value("Notes", fmt.Sprintf("account of %s service number %d", w1, i)),
+		}
+		entry.Tags = w2
+		root.Entries = append(root.Entries, entry)
+	}
+	v := &Vault{db: &gokeepasslib.Database{Content: &gokeepasslib.DBContent{
+		Root: &gokeepasslib.RootData{Groups: []gokeepasslib.Group{root}}}}}
+	if err := v.read(); err != nil {
+		tb.Fatal(err)
+	}
+	return v
+}
+
+// helper function to benchmark search of given queries over 20k records
+func benchSearch(b *testing.B, opts QueryOptions, queries ...string) {
+	v := benchVault(b, 20000)
+	var parsed []*Query
+	for _, query := range queries {
+		q, err := ParseQuery(query, opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		parsed = append(parsed, q)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if len(v.Find(parsed[i%len(parsed)])) == 0 {
+			b.Fatal("no matches")
+		}
+	}
+}
+
+func BenchmarkSearchFuzzy(b *testing.B) {
+	benchSearch(b, QueryOptions{Mode: ModeFuzzy}, "bank 1234", "mail17")
+}
+
+func BenchmarkSearchLiteral(b *testing.B) {
+	benchSearch(b, QueryOptions{}, `"router 123"`, `title:"cloud shop 4"`)
+}
+
+func BenchmarkSearchQuery(b *testing.B) {
+	benchSearch(b, QueryOptions{}, `title:wiki AND user:"user19" NOT tag:chat`)
+}
+
+func BenchmarkIndex(b *testing.B) {
+	v := benchVault(b, 20000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.buildIndex()
+	}
+}
+
+// queries used to compare search via index with linear scan of records
+var testQueries = []string{"bank", "mail17", "ba", "zzz", "bank 1234", "gm",
+	"user1*", "Bank", "secret", "zebra", "login", "example.com/login",
+	`"router 123"`, `title:"cloud shop 4"`, `/sh.p 4/`, `url:""`,
+	`title:wiki AND user:"user19" NOT tag:chat`, "bank OR mail", "NOT bank",
+	"(bank OR wiki) school", "tag:fav OR zebra"}
+
+// options used to compare search via index with linear scan of records
+var testOptions = []QueryOptions{{}, {Mode: ModeFuzzy}, {Mode: ModeRegex},
+	{Mode: ModeGlob}, {CaseSensitive: true}, {AllFields: true},
+	{Mode: ModeFuzzy, AllFields: true}, {IncludeProtected: true}}
+
+// helper function to return IDs of given matches
+func matchIDs(matches []Match) []int {
+	rids := []int{}
+	for _, m := range matches {
+		rids = append(rids, m.ID)
+	}
+	return rids
+}
+
+// helper function to check that search via index candidates returns the
+// same matches as linear scan of all records
+func checkCandidates(t *testing.T, v *Vault) {
+	t.Helper()
+	total := 0
+	for _, opts := range testOptions {
+		for _, query := range testQueries {
+			q, err := ParseQuery(query, opts)
+			if err != nil {
+				t.Fatalf("query %q with %+v: %v", query, opts, err)
+			}
+			got := matchIDs(v.Find(q))
+			want := matchIDs(v.match(q, v.index.rids))
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("query %q with %+v: index returned %v, linear scan %v", query, opts, got, want)
+			}
+			total += len(want)
+		}
+	}
+	if total == 0 {
+		t.Fatal("no records matched")
+	}
+}
+
+// helper function to check that search index is the same as rebuilt one
+func checkIndex(t *testing.T, v *Vault) {
+	t.Helper()
+	ix := v.index
+	v.buildIndex()
+	if !reflect.DeepEqual(ix, v.index) {
+		t.Error("updated search index differs from rebuilt one")
+	}
+}
+
+func TestIndexCandidates(t *testing.T) {
+	v := benchVault(t, 2000)
+	if err := v.newSessionKey(); err != nil {
+		t.Fatal(err)
+	}
+	recs := []Record{
+		{"Title": "Bank secret", "UserName": "user1", "password": "secret bank"},
+		{"Title": "Zebra", "URL": "https://zebra.example.org", "password": "zebra", "Tags": "fav"},
+	}
+	if err := v.AddRecords("Root/Servers/Bank", recs); err != nil {
+		t.Fatal(err)
+	}
+	checkCandidates(t, v)
+}
+
+func TestIndexUpdate(t *testing.T) {
+	v := benchVault(t, 2000)
+	if err := v.newSessionKey(); err != nil {
+		t.Fatal(err)
+	}
+	updates := []func() error{
+		func() error { return v.Put("5", Record{"Title": "zebra 5", "password": "secret zebra"}) },
+		func() error { return v.Put("1999", Record{"URL": "https://bank.example.org", "Notes": "moved"}) },
+		func() error { return v.SetFavorite("17", true) },
+		func() error { return v.Touch("17") },
+		func() error { return v.SetFavorite("17", false) },
+		func() error { return v.Put("64", Record{"UserName": "mail17"}) },
+		func() error { return v.Delete("3") },
+		func() error { return v.AddRecords("Root/New", []Record{{"Title": "bank 1234"}}) },
+		func() error { return v.Put("1999", Record{"Title": "gm"}) },
+		// records added before other ones and deleted ones shift IDs of
+		// following records across words of character index
+		func() error {
+			return v.AddRecords("Root", []Record{{"Title": "zebra router"}, {"Title": "mail17", "URL": "https://gm.example.org"}})
+		},
+		func() error { return v.Delete("0") },
+		func() error { return v.Delete("63") },
+		func() error { return v.Delete("1999") },
+		func() error { return v.AddRecords("Root/New", nil) },
+	}
+	for i, update := range updates {
+		if err := update(); err != nil {
+			t.Fatalf("update %d: %v", i, err)
+		}
+		checkIndex(t, v)
+	}
+	checkCandidates(t, v)
+	// IDs of records which follow deleted ones are shifted
+	if rid, _, err := v.Get("Root/zebra 5"); err != nil || rid != 3 {
+		t.Errorf("updated record is not found, %v", err)
+	}
+}
+
+func BenchmarkAddDelete(b *testing.B) {
+	v := benchVault(b, 20000)
+	if err := v.newSessionKey(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := v.AddRecords("Root", []Record{{"Title": "bank 1234"}}); err != nil {
+			b.Fatal(err)
+		}
+		if err := v.Delete(strconv.Itoa(i % 20000)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkIndexUpdate(b *testing.B) {
+	v := benchVault(b, 20000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := v.Touch(strconv.Itoa(i % 20000)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
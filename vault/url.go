@@ -0,0 +1,178 @@
+package vault
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// URL match modes define how record URL host is matched with given one
+const (
+	URLHost      = "host"      // hosts are equal
+	URLSubdomain = "subdomain" // host is equal to record host or its subdomain
+	URLDomain    = "domain"    // hosts have the same registrable domain, e.g. example.com
+)
+
+// URLModes lists supported URL match modes
+var URLModes = []string{URLHost, URLSubdomain, URLDomain}
+
+// URLOptions represents options of URL lookup
+type URLOptions struct {
+	Match  string // URL match mode, domain if empty
+	Strict bool   // require the same scheme and port
+}
+
+// scores used by URL lookup
+const (
+	scoreURLDomain    = 1  // the same registrable domain
+	scoreURLSubdomain = 4  // host is subdomain of record host
+	scoreURLHost      = 8  // the same host
+	scoreURLScheme    = 2  // the same scheme
+	scoreURLPort      = 2  // the same port
+	scoreURLPath      = 3  // path starts with record path
+	scoreURLExact     = 10 // the same URL
+)
+
+// helper function to parse URL, URLs without scheme are treated as https ones
+func parseURL(val string) (*url.URL, error) {
+	val = strings.TrimSpace(val)
+	if !strings.Contains(val, "://") {
+		val = "https://" + val
+	}
+	u, err := url.Parse(val)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("no host in '%s'", val)
+	}
+	return u, nil
+}
+
+// helper function to return port of URL, default ports of http and https
+// schemes are used if URL does not specify it
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+	return ""
+}
+
+// RegistrableDomain returns registrable domain (eTLD+1) of given host
+// according to public suffix list, e.g. example.co.uk for login.example.co.uk.
+// IP addresses and hosts without public suffix are returned as is.
+func RegistrableDomain(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+// helper function to return score of record URL for given URL, it returns
+// -1 if URLs do not match
+func urlScore(page, rec *url.URL, opts URLOptions) int {
+	host := strings.ToLower(page.Hostname())
+	recHost := strings.ToLower(rec.Hostname())
+	score := -1
+	switch {
+	case host == recHost:
+		score = scoreURLHost
+	case strings.HasSuffix(host, "."+recHost):
+		if opts.Match != URLHost {
+			score = scoreURLSubdomain
+		}
+	case RegistrableDomain(host) == RegistrableDomain(recHost):
+		if opts.Match == URLDomain || opts.Match == "" {
+			score = scoreURLDomain
+		}
+	}
+	if score < 0 {
+		return -1
+	}
+	sameScheme := strings.EqualFold(page.Scheme, rec.Scheme)
+	samePort := urlPort(page) == urlPort(rec)
+	if opts.Strict && (!sameScheme || !samePort) {
+		return -1
+	}
+	if sameScheme {
+		score += scoreURLScheme
+	}
+	if samePort {
+		score += scoreURLPort
+	}
+	if path := strings.TrimSuffix(rec.Path, "/"); path != "" && strings.HasPrefix(page.Path, path) {
+		score += scoreURLPath
+	}
+	if strings.EqualFold(host, recHost) && sameScheme && samePort &&
+		strings.TrimSuffix(page.Path, "/") == strings.TrimSuffix(rec.Path, "/") {
+		score += scoreURLExact
+	}
+	return score
+}
+
+// CheckURLMode checks if given URL match mode is supported
+func CheckURLMode(mode string) error {
+	if mode == "" {
+		return nil
+	}
+	for _, m := range URLModes {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported URL match mode '%s', supported modes: %s",
+		mode, strings.Join(URLModes, ","))
+}
+
+// FindURL returns records whose URL matches given URL, e.g. URL of a login
+// page, the best candidates are returned first
+func (v *Vault) FindURL(rawURL string, opts URLOptions) ([]Match, error) {
+	if err := CheckURLMode(opts.Match); err != nil {
+		return nil, err
+	}
+	page, err := parseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL, %v", err)
+	}
+	var matches []Match
+	for rid, entry := range v.entries {
+		val := entry.GetContent("URL")
+		if val == "" {
+			continue
+		}
+		rec, err := parseURL(val)
+		if err != nil {
+			continue
+		}
+		score := urlScore(page, rec, opts)
+		if score < 0 {
+			continue
+		}
+		fm := FieldMatch{Key: "URL", Value: val}
+		if idx := strings.Index(val, rec.Hostname()); idx >= 0 {
+			fm.Spans = []Span{{Start: idx, End: idx + len(rec.Hostname())}}
+		}
+		matches = append(matches, Match{ID: rid, Score: score, Fields: []FieldMatch{fm}})
+	}
+	SortMatches(matches)
+	return matches, nil
+}
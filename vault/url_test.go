@@ -0,0 +1,88 @@
+package vault
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegistrableDomain(t *testing.T) {
+	tests := []struct {
+		host, want string
+	}{
+		{"example.com", "example.com"},
+		{"login.example.com", "example.com"},
+		{"WWW.Example.COM.", "example.com"},
+		{"login.example.co.uk", "example.co.uk"},
+		{"alice.github.io", "alice.github.io"},
+		{"a.b.alice.github.io", "alice.github.io"},
+		{"localhost", "localhost"},
+		{"10.0.0.1", "10.0.0.1"},
+		{"::1", "::1"},
+	}
+	for _, tt := range tests {
+		if got := RegistrableDomain(tt.host); got != tt.want {
+			t.Errorf("RegistrableDomain(%q): got %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+// URLs of records used to test URL lookup
+var urlRecords = []testRecord{
+	{values: map[string]string{"Title": "Google accounts", "URL": "https://accounts.google.com"}},
+	{values: map[string]string{"Title": "Google", "URL": "https://google.com"}},
+	{values: map[string]string{"Title": "Example login", "URL": "https://login.example.co.uk/signin"}},
+	{values: map[string]string{"Title": "Example admin", "URL": "http://example.co.uk:8080"}},
+	{values: map[string]string{"Title": "GitHub", "URL": "github.com/login"}},
+	{values: map[string]string{"Title": "Router", "URL": "192.168.1.1"}},
+	{values: map[string]string{"Title": "Pages", "URL": "https://bob.github.io"}},
+	{values: map[string]string{"Title": "No URL"}},
+}
+
+func TestFindURL(t *testing.T) {
+	v := testVault(t, urlRecords)
+	tests := []struct {
+		url  string
+		opts URLOptions
+		want []int
+	}{
+		{url: "https://accounts.google.com/signin", want: []int{0, 1}},
+		{url: "accounts.google.com", opts: URLOptions{Match: URLHost}, want: []int{0}},
+		{url: "https://mail.google.com", opts: URLOptions{Match: URLSubdomain}, want: []int{1}},
+		{url: "https://mail.google.com", opts: URLOptions{Match: URLDomain}, want: []int{1, 0}},
+		{url: "https://google.com", opts: URLOptions{Match: URLSubdomain}, want: []int{1}},
+		{url: "https://www.example.co.uk", want: []int{2, 3}},
+		{url: "https://www.example.co.uk", opts: URLOptions{Strict: true}, want: []int{2}},
+		{url: "https://login.example.co.uk/signin/form", want: []int{2, 3}},
+		{url: "http://example.co.uk:8080/", opts: URLOptions{Match: URLHost}, want: []int{3}},
+		{url: "http://example.co.uk:8080/", opts: URLOptions{Match: URLHost, Strict: true}, want: []int{3}},
+		{url: "https://example.co.uk", opts: URLOptions{Match: URLHost, Strict: true}, want: []int{}},
+		{url: "https://github.com/login?next=/", want: []int{4}},
+		{url: "http://192.168.1.1/admin", want: []int{5}},
+		{url: "http://192.168.1.2", want: []int{}},
+		{url: "https://alice.github.io", want: []int{}},
+		{url: "https://www.bob.github.io", want: []int{6}},
+	}
+	for _, tt := range tests {
+		matches, err := v.FindURL(tt.url, tt.opts)
+		if err != nil {
+			t.Errorf("URL %q: %v", tt.url, err)
+			continue
+		}
+		if got := matchIDs(matches); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("URL %q with %+v: got %v, want %v", tt.url, tt.opts, got, tt.want)
+		}
+	}
+	for _, url := range []string{"", "https://", "http://[::1"} {
+		if _, err := v.FindURL(url, URLOptions{}); err == nil {
+			t.Errorf("invalid URL %q is accepted", url)
+		}
+	}
+	if _, err := v.FindURL("google.com", URLOptions{Match: "exact"}); err == nil {
+		t.Error("unsupported URL match mode is accepted")
+	}
+}
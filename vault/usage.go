@@ -0,0 +1,130 @@
+package vault
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	gokeepasslib "github.com/tobischo/gokeepasslib/v3"
+	wrappers "github.com/tobischo/gokeepasslib/v3/wrappers"
+)
+
+// FavoriteTag defines tag of favorite records, tags are understood by other
+// KeePass clients as well
+const FavoriteTag = "favorite"
+
+// scores used to boost records which are used often
+const (
+	scoreFavorite = 15 // favorite record
+	scoreRecent   = 10 // record was used within last day
+	scoreWeek     = 5  // record was used within last week
+	maxUsageScore = 10 // maximal score of usage count
+)
+
+// helper function to split record tags
+func splitTags(tags string) []string {
+	var out []string
+	for _, tag := range strings.FieldsFunc(tags, func(r rune) bool { return r == ',' || r == ';' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+// IsFavorite reports if given entry is marked as favorite
+func IsFavorite(entry gokeepasslib.Entry) bool {
+	for _, tag := range splitTags(entry.Tags) {
+		if strings.EqualFold(tag, FavoriteTag) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to return ranking boost of given entry based on its usage
+func usageScore(entry *gokeepasslib.Entry, now time.Time) int {
+	score := 0
+	if IsFavorite(*entry) {
+		score += scoreFavorite
+	}
+	if entry.Times.UsageCount > 0 && entry.Times.LastAccessTime != nil {
+		switch age := now.Sub(entry.Times.LastAccessTime.Time); {
+		case age < 24*time.Hour:
+			score += scoreRecent
+		case age < 7*24*time.Hour:
+			score += scoreWeek
+		}
+	}
+	if count := int(entry.Times.UsageCount); count < maxUsageScore {
+		score += count
+	} else {
+		score += maxUsageScore
+	}
+	return score
+}
+
+// Touch records usage of given record, i.e. increments its usage count and
+// sets its last access time. Usage is not considered as database change,
+// it is kept in memory and written along with the next change by Save.
+func (v *Vault) Touch(key string) error {
+	return v.modify(key, func(entry *gokeepasslib.Entry) error {
+		entry.Times.UsageCount++
+		entry.Times.LastAccessTime = &wrappers.TimeWrapper{Time: time.Now()}
+		return nil
+	})
+}
+
+// SetFavorite adds or removes favorite tag of given record. Changes are kept
+// in memory until Save is called.
+func (v *Vault) SetFavorite(key string, fav bool) error {
+	return v.modify(key, func(entry *gokeepasslib.Entry) error {
+		v.dirty = true
+		var tags []string
+		for _, tag := range splitTags(entry.Tags) {
+			if !strings.EqualFold(tag, FavoriteTag) {
+				tags = append(tags, tag)
+			}
+		}
+		if fav {
+			tags = append(tags, FavoriteTag)
+		}
+		entry.Tags = strings.Join(tags, ",")
+		entry.Times.LastModificationTime = &wrappers.TimeWrapper{Time: time.Now()}
+		return nil
+	})
+}
+
+// Favorites returns sorted IDs of favorite records
+func (v *Vault) Favorites() []int {
+	var rids []int
+	for _, rid := range v.IDs() {
+		if IsFavorite(v.entries[rid]) {
+			rids = append(rids, rid)
+		}
+	}
+	return rids
+}
+
+// Recent returns IDs of used records, most recently used records are
+// returned first
+func (v *Vault) Recent() []int {
+	var rids []int
+	for _, rid := range v.IDs() {
+		times := v.entries[rid].Times
+		if times.UsageCount > 0 && times.LastAccessTime != nil {
+			rids = append(rids, rid)
+		}
+	}
+	sort.SliceStable(rids, func(i, j int) bool {
+		ti := v.entries[rids[i]].Times.LastAccessTime.Time
+		tj := v.entries[rids[j]].Times.LastAccessTime.Time
+		return ti.After(tj)
+	})
+	return rids
+}
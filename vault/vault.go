@@ -0,0 +1,655 @@
+// Package vault provides access to KeePass databases used by kpass, it keeps
+// protected values sealed in memory and reports failures via errors.
+package vault
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	gokeepasslib "github.com/tobischo/gokeepasslib/v3"
+	wrappers "github.com/tobischo/gokeepasslib/v3/wrappers"
+)
+
+// ErrNotFound is returned when requested record does not exist
+var ErrNotFound = errors.New("record not found")
+
+// ErrWrongPassword is returned when database can't be opened with given
+// credentials
+var ErrWrongPassword = errors.New("wrong password")
+
+// ErrLocked is returned when vault is used after it was locked
+var ErrLocked = errors.New("vault is locked")
+
+// ErrCorrupt is returned when database file can't be decoded
+var ErrCorrupt = errors.New("corrupted database file")
+
+// ErrFileNotFound is returned when database or key file does not exist
+var ErrFileNotFound = errors.New("file not found")
+
+// ErrWriteConflict is returned when database file was modified by another
+// program after it was opened
+var ErrWriteConflict = errors.New("write conflict")
+
+// Record represents record attributes, e.g. title, username or password
+type Record map[string]string
+
+// Vault represents opened KeePass database
+type Vault struct {
+	path    string                     // path to kdbx file
+	kfile   string                     // key file name
+	pwd     []byte                     // database password
+	key     []byte                     // session key to seal protected values
+	modTime time.Time                  // modification time of kdbx file when it was opened
+	newTime time.Time                  // modification time of SavePath file read or written by the vault
+	db      *gokeepasslib.Database     // database object
+	entries map[int]gokeepasslib.Entry // database records
+	groups  map[int]string             // group paths of database records
+	index   *index                     // search index of database records
+	dirty   bool                       // database has changes which are not saved
+	saved   bool                       // database was written to SavePath
+}
+
+// Open opens KeePass database with given key file (optional) and password.
+// The password buffer is owned by the vault, it is locked in memory and
+// wiped when vault is locked.
+func Open(path, kfile string, password []byte) (*Vault, error) {
+	v := &Vault{path: path, kfile: kfile}
+	if err := v.Unlock(password); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Unlock opens locked vault again with given password, if database was
+// written by Save its new file is read. The password buffer is owned by the
+// vault, it is locked in memory and wiped when vault is locked.
+func (v *Vault) Unlock(password []byte) error {
+	LockMemory(password)
+	v.pwd = password
+	if err := v.open(); err != nil {
+		v.Lock()
+		return err
+	}
+	return nil
+}
+
+// helper function to decode database file and seal its protected values
+func (v *Vault) open() error {
+	// modification time of original file is used to detect write conflicts
+	// even if new file written by Save is read
+	info, err := os.Stat(v.path)
+	if err != nil {
+		return FileError(err)
+	}
+	v.modTime = info.ModTime()
+	fname := v.path
+	if info, err := os.Stat(v.SavePath()); v.saved && err == nil {
+		fname, v.newTime = v.SavePath(), info.ModTime()
+	}
+	file, err := os.Open(fname)
+	if err != nil {
+		return FileError(err)
+	}
+	defer file.Close()
+
+	creds, err := v.credentials()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return FileError(err)
+		}
+		return fmt.Errorf("%w, unable to get credentials, %v", ErrWrongPassword, err)
+	}
+	defer wipeCredentials(creds)
+	db := gokeepasslib.NewDatabase()
+	db.Credentials = creds
+	if err := decode(file, db); err != nil {
+		return err
+	}
+	// credentials are built again when database is written
+	db.Credentials = nil
+	if db.Content.Root == nil {
+		return fmt.Errorf("%w, database has no content", ErrCorrupt)
+	}
+	// protected values are kept sealed with random session key and
+	// only unsealed when they are accessed
+	db.UnlockProtectedEntries()
+	if err := v.newSessionKey(); err != nil {
+		return err
+	}
+	if err := v.sealGroups(db.Content.Root.Groups); err != nil {
+		return fmt.Errorf("unable to seal protected entries, %v", err)
+	}
+	v.db = db
+	return v.read()
+}
+
+// FileError wraps file errors, missing files are reported via ErrFileNotFound
+func FileError(err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w, %v", ErrFileNotFound, err)
+	}
+	return err
+}
+
+// helper function to decode database file, the decoder may panic on
+// truncated files which are reported as corrupted ones
+func decode(file *os.File, db *gokeepasslib.Database) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w, %v", ErrCorrupt, r)
+		}
+	}()
+	if err := gokeepasslib.NewDecoder(file).Decode(db); err != nil {
+		return decodeError(err)
+	}
+	return nil
+}
+
+// helper function to classify decoder errors, the decoder reports wrong
+// credentials only via its error messages
+func decodeError(err error) error {
+	if errors.Is(err, gokeepasslib.ErrInvalidDatabaseOrCredentials) ||
+		strings.HasPrefix(err.Error(), "Wrong password?") {
+		return fmt.Errorf("%w, %v", ErrWrongPassword, err)
+	}
+	return fmt.Errorf("%w, %v", ErrCorrupt, err)
+}
+
+// helper function to return database credentials
+func (v *Vault) credentials() (*gokeepasslib.DBCredentials, error) {
+	// credentials are built from hash of password buffer instead of library
+	// constructors which copy password into a string which can't be wiped
+	sum := sha256.Sum256(v.pwd)
+	creds := &gokeepasslib.DBCredentials{Passphrase: make([]byte, len(sum))}
+	copy(creds.Passphrase, sum[:])
+	Wipe(sum[:])
+	LockMemory(creds.Passphrase)
+	if v.kfile != "" {
+		key, err := gokeepasslib.ParseKeyFile(v.kfile)
+		if err != nil {
+			wipeCredentials(creds)
+			return nil, err
+		}
+		LockMemory(key)
+		creds.Key = key
+	}
+	return creds, nil
+}
+
+// helper function to wipe hashed password and key of given credentials
+func wipeCredentials(creds *gokeepasslib.DBCredentials) {
+	if creds == nil {
+		return
+	}
+	Wipe(creds.Passphrase)
+	UnlockMemory(creds.Passphrase)
+	Wipe(creds.Key)
+	UnlockMemory(creds.Key)
+}
+
+// Path returns path of database file
+func (v *Vault) Path() string {
+	return v.path
+}
+
+// SavePath returns path of database file written by Save
+func (v *Vault) SavePath() string {
+	return fmt.Sprintf("%s-new", v.path)
+}
+
+// Name returns name of database, i.e. names of its top level groups
+func (v *Vault) Name() string {
+	if v.db == nil {
+		return ""
+	}
+	var names []string
+	for _, g := range v.db.Content.Root.Groups {
+		names = append(names, g.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// Len returns number of database records
+func (v *Vault) Len() int {
+	return len(v.entries)
+}
+
+// IDs returns sorted IDs of database records
+func (v *Vault) IDs() []int {
+	var rids []int
+	for rid := range v.entries {
+		rids = append(rids, rid)
+	}
+	sort.Ints(rids)
+	return rids
+}
+
+// Entry returns database entry of given record ID
+func (v *Vault) Entry(rid int) (gokeepasslib.Entry, bool) {
+	entry, ok := v.entries[rid]
+	return entry, ok
+}
+
+// Group returns group path of given record ID
+func (v *Vault) Group(rid int) string {
+	return v.groups[rid]
+}
+
+// Locked reports if vault is locked
+func (v *Vault) Locked() bool {
+	return v.db == nil
+}
+
+// Dirty reports if database has changes which are not saved yet
+func (v *Vault) Dirty() bool {
+	return v.dirty
+}
+
+// helper function to read db records
+func (v *Vault) read() error {
+	if err := v.readRecords(); err != nil {
+		return err
+	}
+	v.buildIndex()
+	return nil
+}
+
+// helper function to read records of the database along with their group
+// paths, the search index is not changed
+func (v *Vault) readRecords() error {
+	v.entries = make(map[int]gokeepasslib.Entry)
+	v.groups = make(map[int]string)
+
+	rid := 0
+	for _, top := range v.db.Content.Root.Groups {
+		if top.Name == "NewDatabase" {
+			return fmt.Errorf("%w or empty database", ErrWrongPassword)
+		}
+		v.readGroup(top, top.Name, &rid)
+	}
+	return nil
+}
+
+// helper function to read records of given group and its sub-groups
+func (v *Vault) readGroup(group gokeepasslib.Group, path string, rid *int) {
+	for _, entry := range group.Entries {
+		v.entries[*rid] = entry
+		v.groups[*rid] = path
+		*rid += 1
+	}
+	for _, sub := range group.Groups {
+		v.readGroup(sub, fmt.Sprintf("%s/%s", path, sub.Name), rid)
+	}
+}
+
+// Get returns record ID and entry of given key, the key is either record
+// ID, title or group/title path of the record
+func (v *Vault) Get(key string) (int, gokeepasslib.Entry, error) {
+	if v.db == nil {
+		return 0, gokeepasslib.Entry{}, ErrLocked
+	}
+	if rid, err := strconv.Atoi(key); err == nil {
+		if entry, ok := v.entries[rid]; ok {
+			return rid, entry, nil
+		}
+		return 0, gokeepasslib.Entry{}, fmt.Errorf("%w: %d", ErrNotFound, rid)
+	}
+	var rids []int
+	for rid, entry := range v.entries {
+		title := entry.GetTitle()
+		if key == title || key == fmt.Sprintf("%s/%s", v.groups[rid], title) {
+			rids = append(rids, rid)
+		}
+	}
+	if len(rids) == 0 {
+		return 0, gokeepasslib.Entry{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if len(rids) > 1 {
+		sort.Ints(rids)
+		return 0, gokeepasslib.Entry{}, fmt.Errorf("record %s is ambiguous, matched records %v", key, rids)
+	}
+	return rids[0], v.entries[rids[0]], nil
+}
+
+// FieldKey returns entry key for given attribute name, it maps common
+// lower-case attributes to KeePass keys and matches custom ones regardless
+// of their case
+func FieldKey(entry gokeepasslib.Entry, attr string) string {
+	keys := map[string]string{
+		"password": "Password",
+		"title":    "Title",
+		"username": "UserName",
+		"login":    "Login",
+		"email":    "EMail",
+		"url":      "URL",
+		"notes":    "Notes",
+	}
+	if key, ok := keys[strings.ToLower(attr)]; ok {
+		return key
+	}
+	for _, val := range entry.Values {
+		if strings.EqualFold(val.Key, attr) {
+			return val.Key
+		}
+	}
+	return attr
+}
+
+// helper function to make entry db value
+func mkValue(key string, value string) gokeepasslib.ValueData {
+	return gokeepasslib.ValueData{Key: key, Value: gokeepasslib.V{Content: value}}
+}
+
+// helper function to make protected entry db value
+func mkProtectedValue(key string, value string) gokeepasslib.ValueData {
+	return gokeepasslib.ValueData{
+		Key:   key,
+		Value: gokeepasslib.V{Content: value, Protected: wrappers.NewBoolWrapper(true)},
+	}
+}
+
+// helper function to set or replace value of given entry
+func setValue(entry *gokeepasslib.Entry, val gokeepasslib.ValueData) {
+	if ptr := entry.Get(val.Key); ptr != nil {
+		*ptr = val
+		return
+	}
+	entry.Values = append(entry.Values, val)
+}
+
+// helper function to set record attributes to given entry
+func (v *Vault) setValues(entry *gokeepasslib.Entry, rec Record) error {
+	for key, val := range rec {
+		attr := strings.ToLower(key)
+		if attr == "password" || attr == "otp" {
+			buf := []byte(val)
+			sealed, err := v.seal(buf)
+			Wipe(buf)
+			if err != nil {
+				return fmt.Errorf("unable to seal %s, %v", attr, err)
+			}
+			// KeePassXC keeps TOTP settings in protected otp field
+			key = "Password"
+			if attr == "otp" {
+				key = "otp"
+			}
+			setValue(entry, mkProtectedValue(key, sealed))
+		} else if attr == "tags" {
+			entry.Tags = val
+		} else {
+			key = strings.Title(key)
+			if attr == "username" {
+				key = "UserName"
+			} else if attr == "url" {
+				key = "URL"
+			}
+			setValue(entry, mkValue(key, val))
+		}
+	}
+	return nil
+}
+
+// helper function to modify entry of given record key in place, i.e. group
+// structure of the database is preserved, and update its search index
+func (v *Vault) modify(key string, fn func(*gokeepasslib.Entry) error) error {
+	if v.db == nil {
+		return ErrLocked
+	}
+	_, recEntry, err := v.Get(key)
+	if err != nil {
+		return err
+	}
+	// groups are walked in the same order as records are read, therefore
+	// record IDs of modified entries are known
+	rid := 0
+	var walk func(groups []gokeepasslib.Group) error
+	walk = func(groups []gokeepasslib.Group) error {
+		for i := range groups {
+			for j := range groups[i].Entries {
+				entry := &groups[i].Entries[j]
+				if entry.UUID == recEntry.UUID {
+					if err := fn(entry); err != nil {
+						return err
+					}
+					v.entries[rid] = *entry
+					v.updateIndex(rid)
+				}
+				rid++
+			}
+			if err := walk(groups[i].Groups); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(v.db.Content.Root.Groups)
+}
+
+// Put stores given record attributes, if key is empty new record is created
+// in top level group otherwise attributes of existing record are updated.
+// Changes are kept in memory until Save is called.
+func (v *Vault) Put(key string, rec Record) error {
+	if v.db == nil {
+		return ErrLocked
+	}
+	if len(rec) == 0 {
+		return errors.New("empty record")
+	}
+	if key == "" {
+		return v.AddRecords("", []Record{rec})
+	}
+	return v.modify(key, func(entry *gokeepasslib.Entry) error {
+		v.dirty = true
+		// values are shared with copies of the entry
+		values := make([]gokeepasslib.ValueData, len(entry.Values))
+		copy(values, entry.Values)
+		entry.Values = values
+		if err := v.setValues(entry, rec); err != nil {
+			return err
+		}
+		entry.Times.LastModificationTime = &wrappers.TimeWrapper{Time: time.Now()}
+		return nil
+	})
+}
+
+// AddRecords adds given records to the group of given path, e.g. Root/Imported,
+// missing groups are created and group structure of the database is
+// preserved. Changes are kept in memory until Save is called.
+func (v *Vault) AddRecords(path string, recs []Record) error {
+	if v.db == nil {
+		return ErrLocked
+	}
+	var entries []gokeepasslib.Entry
+	for _, rec := range recs {
+		entry := gokeepasslib.NewEntry()
+		if err := v.setValues(&entry, rec); err != nil {
+			return err
+		}
+		if entry.GetTitle() == "" {
+			setValue(&entry, mkValue("Title", "Record"))
+		}
+		entries = append(entries, entry)
+	}
+	groups := v.db.Content.Root.Groups
+	if len(groups) == 0 {
+		return errors.New("database has no groups")
+	}
+	// path is relative to the top level group and may include its name
+	names := strings.Split(strings.Trim(path, "/"), "/")
+	if names[0] == groups[0].Name {
+		names = names[1:]
+	}
+	group := &groups[0]
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		var sub *gokeepasslib.Group
+		for i := range group.Groups {
+			if group.Groups[i].Name == name {
+				sub = &group.Groups[i]
+				break
+			}
+		}
+		if sub == nil {
+			newGroup := gokeepasslib.NewGroup()
+			newGroup.Name = name
+			group.Groups = append(group.Groups, newGroup)
+			sub = &group.Groups[len(group.Groups)-1]
+		}
+		group = sub
+	}
+	group.Entries = append(group.Entries, entries...)
+	v.dirty = true
+	if len(entries) == 0 {
+		return nil
+	}
+	// IDs of records which follow the group are shifted, therefore records
+	// are read again and new ones are spliced into the index
+	if err := v.readRecords(); err != nil {
+		return err
+	}
+	for rid := 0; rid < len(v.entries); rid++ {
+		if v.entries[rid].UUID == entries[0].UUID {
+			v.spliceIndex(rid, 0, len(entries))
+			return nil
+		}
+	}
+	v.buildIndex()
+	return nil
+}
+
+// Delete removes record of given key from the database, group structure of
+// the database is preserved
+func (v *Vault) Delete(key string) error {
+	if v.db == nil {
+		return ErrLocked
+	}
+	rid, recEntry, err := v.Get(key)
+	if err != nil {
+		return err
+	}
+	var walk func(groups []gokeepasslib.Group)
+	walk = func(groups []gokeepasslib.Group) {
+		for i := range groups {
+			var entries []gokeepasslib.Entry
+			for _, entry := range groups[i].Entries {
+				if entry.UUID != recEntry.UUID {
+					entries = append(entries, entry)
+				}
+			}
+			groups[i].Entries = entries
+			walk(groups[i].Groups)
+		}
+	}
+	walk(v.db.Content.Root.Groups)
+	v.dirty = true
+	// IDs of records which follow deleted one are shifted, therefore records
+	// are read again and deleted one is spliced out of the index
+	if err := v.readRecords(); err != nil {
+		return err
+	}
+	v.spliceIndex(rid, 1, 0)
+	return nil
+}
+
+// Save writes database changes to the new database file, see SavePath
+func (v *Vault) Save() error {
+	if v.db == nil {
+		return ErrLocked
+	}
+	if !v.dirty {
+		return nil
+	}
+	// make sure that nobody changed database file since it was opened
+	if info, err := os.Stat(v.path); err != nil {
+		return FileError(err)
+	} else if !info.ModTime().Equal(v.modTime) {
+		return fmt.Errorf("%w, %s was modified at %s after it was opened", ErrWriteConflict, v.path, info.ModTime().Format(time.RFC3339))
+	}
+	// new file is only overwritten if it was read or written by the vault,
+	// otherwise changes written by another session would be lost
+	if info, err := os.Stat(v.SavePath()); err == nil && !info.ModTime().Equal(v.newTime) {
+		return fmt.Errorf("%w, %s was written by another session, move it to %s or remove it",
+			ErrWriteConflict, v.SavePath(), v.path)
+	}
+	if err := v.write(v.db.Content.Root.Groups); err != nil {
+		return err
+	}
+	info, err := os.Stat(v.SavePath())
+	if err != nil {
+		return FileError(err)
+	}
+	v.newTime = info.ModTime()
+	v.dirty = false
+	v.saved = true
+	return nil
+}
+
+// helper function to write new database file with given root groups
+func (v *Vault) write(groups []gokeepasslib.Group) error {
+	// write group entries to DB
+	// https://github.com/tobischo/gokeepasslib/blob/master/examples/writing/example-writing.go
+	creds, err := v.credentials()
+	if err != nil {
+		return err
+	}
+	defer wipeCredentials(creds)
+	// unseal protected values right before they are locked by the encoder,
+	// records of sub-groups are unsealed as well
+	groups, err = v.unsealGroups(groups)
+	if err != nil {
+		return err
+	}
+	newdb := &gokeepasslib.Database{
+		Header:      gokeepasslib.NewHeader(),
+		Credentials: creds,
+		Content: &gokeepasslib.DBContent{
+			Meta: gokeepasslib.NewMetaData(),
+			Root: &gokeepasslib.RootData{
+				Groups: groups,
+			},
+		},
+	}
+	// keep database custom data, e.g. saved searches
+	if meta := v.db.Content.Meta; meta != nil {
+		newdb.Content.Meta.CustomData = meta.CustomData
+	}
+	file, err := os.Create(v.SavePath())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Lock entries using stream cipher
+	newdb.LockProtectedEntries()
+
+	// and encode it into the file
+	return gokeepasslib.NewEncoder(file).Encode(newdb)
+}
+
+// Lock wipes database password, session key and records of the vault, the
+// vault can't be used afterwards unless it is unlocked
+func (v *Vault) Lock() {
+	Wipe(v.pwd)
+	UnlockMemory(v.pwd)
+	Wipe(v.key)
+	UnlockMemory(v.key)
+	v.pwd = nil
+	v.key = nil
+	v.db = nil
+	v.entries = nil
+	v.groups = nil
+	v.index = nil
+}
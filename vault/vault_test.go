@@ -0,0 +1,74 @@
+package vault
+
+// kpass - command line interface for KeePass
+//
+// Copyright (c) 2023 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	gokeepasslib "github.com/tobischo/gokeepasslib/v3"
+)
+
+// helper function to create database file with given root groups
+func testDatabase(t *testing.T, groups ...string) string {
+	v := &Vault{path: filepath.Join(t.TempDir(), "test.kdbx"), pwd: []byte("test")}
+	if err := v.newSessionKey(); err != nil {
+		t.Fatal(err)
+	}
+	root := &gokeepasslib.RootData{}
+	for _, name := range groups {
+		group := gokeepasslib.NewGroup()
+		group.Name = name
+		entry := gokeepasslib.NewEntry()
+		entry.Values = []gokeepasslib.ValueData{mkValue("Title", name+" record")}
+		group.Entries = append(group.Entries, entry)
+		root.Groups = append(root.Groups, group)
+	}
+	v.db = &gokeepasslib.Database{Content: &gokeepasslib.DBContent{Meta: gokeepasslib.NewMetaData(), Root: root}}
+	if err := v.write(root.Groups); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(v.SavePath(), v.path); err != nil {
+		t.Fatal(err)
+	}
+	return v.path
+}
+
+func TestSaveRootGroups(t *testing.T) {
+	path := testDatabase(t, "Personal", "Work")
+	v, err := Open(path, "", []byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Put("", Record{"title": "new", "password": "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Open(v.SavePath(), "", []byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := saved.Name(); name != "Personal,Work" {
+		t.Errorf("root groups of saved database: got %q, want %q", name, "Personal,Work")
+	}
+	if n := saved.Len(); n != 3 {
+		t.Errorf("records of saved database: got %d, want 3", n)
+	}
+	_, entry, err := saved.Get("Personal/new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pwd, err := saved.Reveal(entry, "Password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pwd) != "secret" {
+		t.Errorf("password of saved record: got %q, want %q", pwd, "secret")
+	}
+}